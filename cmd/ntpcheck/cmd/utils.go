@@ -18,7 +18,6 @@ package cmd
 
 import (
 	"crypto/md5"
-	"encoding/binary"
 	"fmt"
 	"math"
 	"net"
@@ -130,7 +129,11 @@ func ntpDate(remoteServerAddr string, remoteServerPort string, requests int) err
 			TxTimeFrac: frac,
 		}
 
-		if err := binary.Write(conn, binary.BigEndian, request); err != nil {
+		requestBytes, err := request.Bytes()
+		if err != nil {
+			return fmt.Errorf("failed to encode request, %w", err)
+		}
+		if _, err := conn.Write(requestBytes); err != nil {
 			return fmt.Errorf("failed to send request, %w", err)
 		}
 
@@ -275,11 +278,11 @@ func init() {
 	ntpdateCmd.Flags().IntVarP(&ntpdateRequests, "requests", "r", 3, "How many requests to send")
 	// printleap
 	utilsCmd.AddCommand(printLeapCmd)
-	printLeapCmd.Flags().StringVarP(&sourceLeapSeconds, "srcfile", "s", "/usr/share/zoneinfo/right/UTC", "Source file of leap seconds")
+	printLeapCmd.Flags().StringVarP(&sourceLeapSeconds, "srcfile", "s", leapsectz.DefaultLeapFile, "Source file of leap seconds")
 	// addFakeSecondZoneInfo
 	utilsCmd.AddCommand(addFakeSecondZoneInfoCmd)
 	addFakeSecondZoneInfoCmd.Flags().IntVarP(&offsetMonth, "month", "m", 1, "How many monthes to add to current to insert leap second")
-	addFakeSecondZoneInfoCmd.Flags().StringVarP(&sourceLeapSeconds, "srcfile", "s", "/usr/share/zoneinfo/right/UTC", "Source file of leap seconds")
+	addFakeSecondZoneInfoCmd.Flags().StringVarP(&sourceLeapSeconds, "srcfile", "s", leapsectz.DefaultLeapFile, "Source file of leap seconds")
 	addFakeSecondZoneInfoCmd.Flags().StringVarP(&destLeapSeconds, "dstfile", "d", "/usr/share/zoneinfo/right/Fake", "Destination file for fake leap seconds")
 }
 
@@ -0,0 +1,120 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package leapsectz
+
+import (
+	"os"
+	"sync"
+	"time"
+)
+
+// Cache keeps parsed leap seconds from a TZif file in memory.
+// File is parsed on first use and again only when its modification time changes. It's safe for concurrent use
+type Cache struct {
+	path string
+
+	mu          sync.RWMutex
+	leapSeconds []LeapSecond
+	modTime     time.Time
+	loaded      bool
+}
+
+// NewCache returns Cache for TZif file at path. Pass "" to use default file
+func NewCache(path string) *Cache {
+	if path == "" {
+		path = DefaultLeapFile
+	}
+	return &Cache{path: path}
+}
+
+// Refresh re-reads the file regardless of its modification time
+func (c *Cache) Refresh() error {
+	fi, err := os.Stat(c.path)
+	if err != nil {
+		return err
+	}
+	return c.load(fi.ModTime())
+}
+
+// load parses the file and remembers modTime it was parsed at
+func (c *Cache) load(modTime time.Time) error {
+	leapSeconds, err := ParseFrom(c.path)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.leapSeconds = leapSeconds
+	c.modTime = modTime
+	c.loaded = true
+	c.mu.Unlock()
+	return nil
+}
+
+// get returns cached leap seconds, re-reading the file if it changed since the last read.
+// Returned slice must not be modified
+func (c *Cache) get() ([]LeapSecond, error) {
+	fi, err := os.Stat(c.path)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.RLock()
+	fresh := c.loaded && c.modTime.Equal(fi.ModTime())
+	leapSeconds := c.leapSeconds
+	c.mu.RUnlock()
+	if fresh {
+		return leapSeconds, nil
+	}
+
+	if err := c.load(fi.ModTime()); err != nil {
+		return nil, err
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.leapSeconds, nil
+}
+
+// LeapSeconds returns a copy of the cached list of leap seconds
+func (c *Cache) LeapSeconds() ([]LeapSecond, error) {
+	leapSeconds, err := c.get()
+	if err != nil {
+		return nil, err
+	}
+	return append([]LeapSecond(nil), leapSeconds...), nil
+}
+
+// OffsetAt returns the number of leap seconds in effect at t
+func (c *Cache) OffsetAt(t time.Time) (int32, error) {
+	leapSeconds, err := c.get()
+	if err != nil {
+		return 0, err
+	}
+	return OffsetAt(leapSeconds, t), nil
+}
+
+// Latest returns the latest leap second before now.
+// Empty LeapSecond is returned if there is none
+func (c *Cache) Latest() (*LeapSecond, error) {
+	leapSeconds, err := c.get()
+	if err != nil {
+		return nil, err
+	}
+	res := LeapSecond{}
+	if i := lastBefore(leapSeconds, timeNow()); i >= 0 {
+		res = leapSeconds[i]
+	}
+	return &res, nil
+}
@@ -0,0 +1,126 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package leapsectz
+
+import (
+	"bytes"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCache(t *testing.T) {
+	name := writeTemp(t, tzV2)
+	c := NewCache(name)
+
+	ls, err := c.LeapSeconds()
+	require.NoError(t, err)
+	require.Equal(t, []LeapSecond{{78796800, 1}, {94694401, 2}}, ls)
+
+	offset, err := c.OffsetAt(time.Unix(78796800, 0))
+	require.NoError(t, err)
+	require.Equal(t, int32(1), offset)
+
+	latest, err := c.Latest()
+	require.NoError(t, err)
+	require.Equal(t, &LeapSecond{94694401, 2}, latest)
+
+	// modifying returned copy doesn't affect the cache
+	ls[0].Nleap = 42
+	ls, err = c.LeapSeconds()
+	require.NoError(t, err)
+	require.Equal(t, int32(1), ls[0].Nleap)
+}
+
+func TestCacheReloadOnChange(t *testing.T) {
+	name := writeTemp(t, tzV2)
+	c := NewCache(name)
+	offset, err := c.OffsetAt(time.Unix(200000000, 0))
+	require.NoError(t, err)
+	require.Equal(t, int32(2), offset)
+
+	var b bytes.Buffer
+	require.NoError(t, Write(&b, '2', []LeapSecond{{78796800, 1}, {94694401, 2}, {126230402, 3}}, "UTC"))
+	require.NoError(t, os.WriteFile(name, b.Bytes(), 0644))
+	// make sure modification time differs even on filesystems with coarse timestamps
+	later := time.Now().Add(time.Hour)
+	require.NoError(t, os.Chtimes(name, later, later))
+
+	offset, err = c.OffsetAt(time.Unix(200000000, 0))
+	require.NoError(t, err)
+	require.Equal(t, int32(3), offset)
+}
+
+func TestCacheRefresh(t *testing.T) {
+	name := writeTemp(t, tzV2)
+	fi, err := os.Stat(name)
+	require.NoError(t, err)
+	c := NewCache(name)
+	_, err = c.LeapSeconds()
+	require.NoError(t, err)
+
+	// same modification time, only Refresh picks up the change
+	var b bytes.Buffer
+	require.NoError(t, Write(&b, '2', []LeapSecond{{78796800, 1}}, "UTC"))
+	require.NoError(t, os.WriteFile(name, b.Bytes(), 0644))
+	require.NoError(t, os.Chtimes(name, fi.ModTime(), fi.ModTime()))
+
+	ls, err := c.LeapSeconds()
+	require.NoError(t, err)
+	require.Len(t, ls, 2)
+
+	require.NoError(t, c.Refresh())
+	ls, err = c.LeapSeconds()
+	require.NoError(t, err)
+	require.Len(t, ls, 1)
+}
+
+func TestCacheMissingFile(t *testing.T) {
+	c := NewCache(t.TempDir() + "/missing")
+	_, err := c.OffsetAt(time.Now())
+	require.ErrorIs(t, err, os.ErrNotExist)
+	require.ErrorIs(t, c.Refresh(), os.ErrNotExist)
+}
+
+func TestCacheConcurrent(t *testing.T) {
+	name := writeTemp(t, tzV2)
+	c := NewCache(name)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				offset, err := c.OffsetAt(time.Unix(200000000, 0))
+				require.NoError(t, err)
+				require.Equal(t, int32(2), offset)
+			}
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for j := 0; j < 20; j++ {
+			require.NoError(t, c.Refresh())
+		}
+	}()
+	wg.Wait()
+}
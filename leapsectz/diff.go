@@ -0,0 +1,43 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package leapsectz
+
+// Diff compares two leap second tables by Tleap and returns entries of prev missing in next (removed)
+// and entries of next missing in prev (added). An entry with the same Tleap but different Nleap
+// is reported as both removed and added. Results keep the order of the input tables
+func Diff(prev, next []LeapSecond) (added, removed []LeapSecond) {
+	prevByTime := make(map[uint64]int32, len(prev))
+	for _, l := range prev {
+		prevByTime[l.Tleap] = l.Nleap
+	}
+	nextByTime := make(map[uint64]int32, len(next))
+	for _, l := range next {
+		nextByTime[l.Tleap] = l.Nleap
+	}
+
+	for _, l := range prev {
+		if n, ok := nextByTime[l.Tleap]; !ok || n != l.Nleap {
+			removed = append(removed, l)
+		}
+	}
+	for _, l := range next {
+		if n, ok := prevByTime[l.Tleap]; !ok || n != l.Nleap {
+			added = append(added, l)
+		}
+	}
+	return added, removed
+}
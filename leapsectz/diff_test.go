@@ -0,0 +1,50 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package leapsectz
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiff(t *testing.T) {
+	prev := []LeapSecond{{78796800, 1}, {94694401, 2}}
+	next := []LeapSecond{{78796800, 1}, {94694401, 2}, {1483228826, 27}}
+
+	added, removed := Diff(prev, next)
+	require.Equal(t, []LeapSecond{{1483228826, 27}}, added)
+	require.Empty(t, removed)
+
+	// the other way around
+	added, removed = Diff(next, prev)
+	require.Empty(t, added)
+	require.Equal(t, []LeapSecond{{1483228826, 27}}, removed)
+
+	added, removed = Diff(prev, prev)
+	require.Empty(t, added)
+	require.Empty(t, removed)
+}
+
+func TestDiffChangedCount(t *testing.T) {
+	prev := []LeapSecond{{78796800, 1}, {94694401, 2}}
+	next := []LeapSecond{{78796800, 1}, {94694401, 0}}
+
+	added, removed := Diff(prev, next)
+	require.Equal(t, []LeapSecond{{94694401, 0}}, added)
+	require.Equal(t, []LeapSecond{{94694401, 2}}, removed)
+}
@@ -22,17 +22,28 @@ import (
 	"bytes"
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"io"
+	"math"
 	"os"
+	"path/filepath"
 	"time"
 )
 
-// leapFile is a file containing leap second information
-var leapFile = "/usr/share/zoneinfo/right/UTC"
+// DefaultLeapFile is a file containing leap second information used when no file is given
+const DefaultLeapFile = "/usr/share/zoneinfo/right/UTC"
+
+// leapFile is a file Parse("") reads, tests point it elsewhere
+var leapFile = DefaultLeapFile
 
 var errBadData = errors.New("malformed time zone information")
 var errUnsupportedVersion = errors.New("unsupported version")
 var errNoLeapSeconds = errors.New("no leap seconds information found")
+var errNoExpiry = errors.New("no expiration information found")
+var errDuplicateLeap = errors.New("leap second already exists")
+var errLeapOrder = errors.New("leap second is out of order")
+var errV1Range = errors.New("leap second doesn't fit into version 1 file")
+var errBadTZString = errors.New("malformed POSIX TZ string")
 
 // LeapSecond represents a leap second
 type LeapSecond struct {
@@ -40,6 +51,23 @@ type LeapSecond struct {
 	Nleap int32
 }
 
+// taiUTCInitial is TAI-UTC difference in seconds on January 1, 1972, before the first leap second
+const taiUTCInitial = 10
+
+// LeapSecondEntry is a leap second along with the offset in effect after it
+type LeapSecondEntry struct {
+	LeapSecond
+	// Step is +1 for inserted and -1 for deleted leap second
+	Step int32
+	// Offset is the number of leap seconds in effect after this entry
+	Offset int32
+}
+
+// TAIOffset returns TAI-UTC difference in effect after the entry
+func (e LeapSecondEntry) TAIOffset() time.Duration {
+	return time.Duration(taiUTCInitial+e.Offset) * time.Second
+}
+
 // Header represents file header structure. Fields names are copied from doc
 type Header struct {
 	// A four-octet unsigned integer specifying the number of UTC/local indicators contained in the body.
@@ -56,73 +84,475 @@ type Header struct {
 	CharCnt uint32
 }
 
-// Time returns when the leap second event occurs
+// Time returns when the leap second event occurs.
+// Tleap is treated as TZif value: Unix epoch (1970) seconds including the correction in effect before the event.
+// This is what Parse and ParseLeapSecondsList return.
+// It assumes a positive leap second, use the position in the table to handle negative ones
 func (l LeapSecond) Time() time.Time {
 	return time.Unix(int64(l.Tleap-uint64(l.Nleap)+1), 0)
 }
 
+// TimeNTP returns when the leap second event occurs if Tleap holds NTP epoch (1900) seconds
+// of the event as in the first column of leap-seconds.list. No correction is applied
+func (l LeapSecond) TimeNTP() time.Time {
+	return ntpToTime(l.Tleap)
+}
+
+// leapTime returns when i-th leap second of the chronologically ordered ls occurs.
+// Tleap includes correction in effect before the event, which is Nleap-1 for positive
+// and Nleap+1 for negative leap seconds
+func leapTime(ls []LeapSecond, i int) time.Time {
+	var prev int32
+	if i > 0 {
+		prev = ls[i-1].Nleap
+	}
+	return time.Unix(int64(ls[i].Tleap)-int64(prev), 0)
+}
+
 // Parse returns the list of leap seconds from srcfile. Pass "" to use default file
 func Parse(srcfile string) ([]LeapSecond, error) {
 	if srcfile == "" {
-		srcfile = leapFile
+		return ParseFrom(leapFile)
 	}
-	f, err := os.Open(srcfile)
+	return ParseFrom(srcfile)
+}
+
+// ParseFrom returns the list of leap seconds from TZif file at path.
+// Unlike Parse there is no fallback to the default file, use DefaultLeapFile explicitly
+func ParseFrom(path string) ([]LeapSecond, error) {
+	f, err := os.Open(path)
 	if err != nil {
 		return nil, err
 	}
 	defer f.Close()
 
-	return parseVx(f)
+	return ParseReader(f)
+}
+
+// Version returns the TZif version of the file at path without parsing the leap seconds:
+// 0 for version 1, '2' or '3' otherwise
+func Version(path string) (byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
 
+	return readVersion(f)
 }
 
-// Latest returns the latest leap second from srcfile. Pass "" to use default file
-func Latest(srcfile string) (*LeapSecond, error) {
-	res := LeapSecond{}
+// readVersion reads the magic and the version byte from the start of TZif data
+func readVersion(r io.Reader) (byte, error) {
+	b := make([]byte, 5)
+	if _, err := io.ReadFull(r, b); err != nil || string(b[:4]) != "TZif" {
+		return 0, &ErrParse{Offset: 0, Field: "magic", Err: errBadData}
+	}
+	version := b[4]
+	if version != 0 && version != '2' && version != '3' {
+		return 0, &ErrParse{Offset: 4, Field: "version", Err: errUnsupportedVersion}
+	}
+	return version, nil
+}
+
+// ParseBytes returns the list of leap seconds from TZif data already in memory,
+// for example embedded with embed.FS
+func ParseBytes(b []byte) ([]LeapSecond, error) {
+	return parseVx(bytes.NewReader(b))
+}
+
+// ParseWithOffsets returns the list of leap seconds from srcfile along with the offsets they result in.
+// Pass "" to use default file
+func ParseWithOffsets(srcfile string) ([]LeapSecondEntry, error) {
 	leapSeconds, err := Parse(srcfile)
 	if err != nil {
 		return nil, err
 	}
 
+	entries := make([]LeapSecondEntry, 0, len(leapSeconds))
+	var prev int32
 	for _, leapSecond := range leapSeconds {
-		if leapSecond.Time().After(res.Time()) && leapSecond.Time().Before(time.Now()) {
-			res = leapSecond
+		entries = append(entries, LeapSecondEntry{
+			LeapSecond: leapSecond,
+			Step:       leapSecond.Nleap - prev,
+			Offset:     leapSecond.Nleap,
+		})
+		prev = leapSecond.Nleap
+	}
+	return entries, nil
+}
+
+// Expired reports whether leap seconds information in srcfile is past its expiration time, and the expiration time itself.
+// srcfile may be either in leap-seconds.list (#@ line) or TZif format. TZif files mark expiration
+// with the last leap second record repeating the previous correction. Pass "" to use default file
+func Expired(srcfile string) (bool, time.Time, error) {
+	if srcfile == "" {
+		srcfile = leapFile
+	}
+	b, err := os.ReadFile(srcfile)
+	if err != nil {
+		return false, time.Time{}, err
+	}
+
+	var expires time.Time
+	if bytes.HasPrefix(b, []byte("TZif")) {
+		leapSeconds, err := parseVx(bytes.NewReader(b))
+		if err != nil {
+			return false, time.Time{}, err
+		}
+		n := len(leapSeconds)
+		if n < 2 || leapSeconds[n-1].Nleap != leapSeconds[n-2].Nleap {
+			return false, time.Time{}, errNoExpiry
+		}
+		expires = leapTime(leapSeconds, n-1)
+	} else {
+		l, err := parseLeapSecondsList(bytes.NewReader(b))
+		if err != nil {
+			return false, time.Time{}, err
+		}
+		if l.expires.IsZero() {
+			return false, time.Time{}, errNoExpiry
 		}
+		expires = l.expires
+	}
+	return timeNow().After(expires), expires, nil
+}
+
+// AppendLeapSecond adds l to the end of the leap seconds list in TZif file srcfile.
+// Only leap second records are added, everything else in the file (version, transitions,
+// local time types, abbreviations and the footer) is kept as is.
+// l must be chronologically after the last leap second and differ in count by one
+func AppendLeapSecond(srcfile string, l LeapSecond) error {
+	b, err := os.ReadFile(srcfile)
+	if err != nil {
+		return err
+	}
+	leapSeconds, err := parseVx(bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+
+	last := leapSeconds[len(leapSeconds)-1]
+	if l.Tleap == last.Tleap {
+		return fmt.Errorf("%w: %d", errDuplicateLeap, l.Tleap)
+	}
+	leapSeconds = append(leapSeconds, l)
+	if err := validateLeapSeconds(leapSeconds); err != nil {
+		return err
+	}
+	out, err := appendLeapRecord(b, l)
+	if err != nil {
+		return err
+	}
+
+	st, err := os.Stat(srcfile)
+	if err != nil {
+		return err
+	}
+	// write to a temporary file in the same directory and replace the original
+	f, err := os.CreateTemp(filepath.Dir(srcfile), filepath.Base(srcfile)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if err := f.Chmod(st.Mode().Perm()); err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := f.Write(out); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), srcfile)
+}
+
+// appendLeapRecord returns TZif data b with l added after the leap second records of every data block
+// and leap counts in the headers updated accordingly. b must be valid TZif data
+func appendLeapRecord(b []byte, l LeapSecond) ([]byte, error) {
+	// 4-byte magic, 1-byte version, 15 bytes of padding and six counts
+	const headerSize = 4 + 16 + 24
+	// offset of LeapCnt in the header
+	const leapCntOffset = 4 + 16 + 8
+	out := make([]byte, 0, len(b)+8+12)
+	off := 0
+	for v := 0; v < 2; v++ {
+		if len(b) < off+headerSize || string(b[off:off+4]) != "TZif" {
+			return nil, &ErrParse{Offset: off, Field: "header", Err: errBadData}
+		}
+		version := b[off+4]
+		var hdr Header
+		if err := binary.Read(bytes.NewReader(b[off+20:off+headerSize]), binary.BigEndian, &hdr); err != nil {
+			return nil, &ErrParse{Offset: off + 20, Field: "header", Err: err}
+		}
+		// transition time and leap record time are 4 bytes long in version 1 block and 8 bytes in version 2+ block
+		timeSize := 4
+		if v > 0 {
+			timeSize = 8
+		}
+		recordSize := timeSize + 4
+		leapStart := off + headerSize + int(hdr.TimeCnt)*(timeSize+1) + int(hdr.TypeCnt)*6 + int(hdr.CharCnt)
+		leapEnd := leapStart + int(hdr.LeapCnt)*recordSize
+		end := leapEnd + int(hdr.IsStdCnt) + int(hdr.IsUtcCnt)
+		if end > len(b) {
+			return nil, &ErrParse{Offset: off, Field: "data", Err: errBadData}
+		}
+
+		record := make([]byte, recordSize)
+		switch {
+		case v > 0:
+			binary.BigEndian.PutUint64(record, l.Tleap)
+		case l.Tleap <= math.MaxInt32:
+			binary.BigEndian.PutUint32(record, uint32(l.Tleap))
+		case version == 0:
+			return nil, fmt.Errorf("%w: leap second at %d", errV1Range, l.Tleap)
+		default:
+			// version 2+ readers skip version 1 block, leave it without the leap second it can't hold
+			record = nil
+		}
+		if record != nil {
+			binary.BigEndian.PutUint32(record[timeSize:], uint32(l.Nleap))
+			start := len(out)
+			out = append(out, b[off:leapStart]...)
+			binary.BigEndian.PutUint32(out[start+leapCntOffset:], hdr.LeapCnt+1)
+			out = append(out, b[leapStart:leapEnd]...)
+			out = append(out, record...)
+			out = append(out, b[leapEnd:end]...)
+		} else {
+			out = append(out, b[off:end]...)
+		}
+		off = end
+		if version == 0 {
+			break
+		}
+	}
+	// the footer of version 2+ data
+	return append(out, b[off:]...), nil
+}
+
+// Latest returns the latest leap second from srcfile which already happened, ignoring the future ones.
+// Pass "" to use default file
+func Latest(srcfile string) (*LeapSecond, error) {
+	return LatestBefore(srcfile, time.Now())
+}
+
+// LatestBefore returns the latest leap second from srcfile at or before t.
+// Empty LeapSecond is returned if there is none. Pass "" to use default file
+func LatestBefore(srcfile string, t time.Time) (*LeapSecond, error) {
+	res := LeapSecond{}
+	leapSeconds, err := Parse(srcfile)
+	if err != nil {
+		return nil, err
+	}
+
+	// pick by time, Nleap decreases after negative leap seconds
+	if i := lastBefore(leapSeconds, t); i >= 0 {
+		res = leapSeconds[i]
 	}
 
 	return &res, nil
 }
 
-func parseVx(r io.Reader) ([]LeapSecond, error) {
+// lastBefore returns index of the last leap second of ls at or before t, or -1 if there is none
+func lastBefore(ls []LeapSecond, t time.Time) int {
+	res := -1
+	for i := range ls {
+		if leapTime(ls, i).After(t) {
+			break
+		}
+		res = i
+	}
+	return res
+}
+
+// OffsetAt returns the number of leap seconds in effect at t according to ls.
+// It's 0 before the first leap second
+func OffsetAt(ls []LeapSecond, t time.Time) int32 {
+	i := lastBefore(ls, t)
+	if i < 0 {
+		return 0
+	}
+	return ls[i].Nleap
+}
+
+// NextLeap returns the first leap second from ls after now and how long until it occurs,
+// or nil if there is none scheduled
+func NextLeap(ls []LeapSecond, now time.Time) (*LeapSecond, time.Duration) {
+	i := lastBefore(ls, now) + 1
+	if i >= len(ls) {
+		return nil, 0
+	}
+	next := ls[i]
+	return &next, leapTime(ls, i).Sub(now)
+}
+
+// ErrParse is returned when TZif data is malformed. It tells where parsing failed
+type ErrParse struct {
+	// Offset is the byte offset of the field which failed to parse
+	Offset int
+	// Field is the name of the field which failed to parse
+	Field string
+	Err   error
+}
+
+func (e *ErrParse) Error() string {
+	return fmt.Sprintf("%v: %s at offset %d", e.Err, e.Field, e.Offset)
+}
+
+func (e *ErrParse) Unwrap() error {
+	return e.Err
+}
+
+// countingReader counts bytes read so far
+type countingReader struct {
+	r io.Reader
+	n int
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += n
+	return n, err
+}
+
+// remaining returns the number of bytes left in the underlying reader, if it can tell
+func (c *countingReader) remaining() (int64, bool) {
+	switch r := c.r.(type) {
+	case interface{ Len() int }:
+		return int64(r.Len()), true
+	case io.Seeker:
+		cur, err := r.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return 0, false
+		}
+		end, err := r.Seek(0, io.SeekEnd)
+		if err != nil {
+			return 0, false
+		}
+		if _, err := r.Seek(cur, io.SeekStart); err != nil {
+			return 0, false
+		}
+		return end - cur, true
+	}
+	return 0, false
+}
+
+func parseVx(src io.Reader) ([]LeapSecond, error) {
+	return parseBlocks(&countingReader{r: src}, 0)
+}
+
+// ParseReader returns the list of leap seconds from TZif data in r.
+// Unlike Parse it seeks over the version 1 data block of version 2+ files instead of reading it
+func ParseReader(r io.ReadSeeker) ([]LeapSecond, error) {
+	// 4-byte magic, 1-byte version, 15 bytes of padding and six counts
+	const headerSize = 4 + 16 + 24
+	b := make([]byte, headerSize)
+	if _, err := io.ReadFull(r, b); err != nil || string(b[:4]) != "TZif" || b[4] == 0 {
+		// let the regular parser deal with version 1 and malformed data
+		if _, err := r.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		return parseVx(r)
+	}
+
+	var hdr Header
+	if err := binary.Read(bytes.NewReader(b[20:]), binary.BigEndian, &hdr); err != nil {
+		return nil, err
+	}
+	skip := int64(hdr.TimeCnt)*5 + int64(hdr.TypeCnt)*6 + int64(hdr.CharCnt) +
+		int64(hdr.LeapCnt)*8 + int64(hdr.IsUtcCnt) + int64(hdr.IsStdCnt)
+	offset, err := r.Seek(skip, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	return parseBlocks(&countingReader{r: r, n: int(offset)}, 1)
+}
+
+// maxFooterSize limits how much data after the version 2+ block is read as the footer
+const maxFooterSize = 1024
+
+// ParseWithTZString returns the list of leap seconds from TZif data in r along with the POSIX TZ string
+// from the footer of version 2+ data. The footer must be the newline enclosed string and nothing else.
+// Version 1 data has no footer and the string is empty
+func ParseWithTZString(r io.Reader) ([]LeapSecond, string, error) {
+	cr := &countingReader{r: r}
+	ls, err := parseBlocks(cr, 0)
+	if err != nil {
+		return nil, "", err
+	}
+	offset := cr.n
+	footer, err := io.ReadAll(io.LimitReader(cr, maxFooterSize+1))
+	if err != nil {
+		return nil, "", &ErrParse{Offset: offset, Field: "footer", Err: err}
+	}
+	if len(footer) == 0 {
+		// version 1 data ends right after the leap seconds block
+		return ls, "", nil
+	}
+	tz, err := parseFooter(footer)
+	if err != nil {
+		return nil, "", &ErrParse{Offset: offset, Field: "footer", Err: err}
+	}
+	return ls, tz, nil
+}
+
+// parseFooter returns POSIX TZ string from the newline enclosed footer b
+func parseFooter(b []byte) (string, error) {
+	if len(b) > maxFooterSize || len(b) < 2 || b[0] != '\n' || b[len(b)-1] != '\n' {
+		return "", errBadTZString
+	}
+	tz := string(b[1 : len(b)-1])
+	if err := validateTZString(tz); err != nil {
+		return "", err
+	}
+	return tz, nil
+}
+
+// validateTZString checks POSIX TZ string consists of printable ASCII characters only,
+// that rules out the newlines enclosing it in the footer
+func validateTZString(tz string) error {
+	for i := 0; i < len(tz); i++ {
+		if tz[i] < ' ' || tz[i] > '~' {
+			return fmt.Errorf("%w: unexpected character %q at %d", errBadTZString, tz[i], i)
+		}
+	}
+	return nil
+}
+
+// parseBlocks parses TZif data starting from the block v (0 for version 1 block, 1 for version 2+ block)
+func parseBlocks(r *countingReader, v byte) ([]LeapSecond, error) {
 	var ret []LeapSecond
-	var v byte
-	for v = 0; v < 2; v++ {
+	for ; v < 2; v++ {
 		// 4-byte magic "TZif"
 		magic := make([]byte, 4)
+		offset := r.n
 		if _, _ = r.Read(magic); string(magic) != "TZif" {
-			return nil, errBadData
+			return nil, &ErrParse{Offset: offset, Field: "magic", Err: errBadData}
 		}
 
 		// 1-byte version, then 15 bytes of padding
 		var version byte
 		p := make([]byte, 16)
+		offset = r.n
 		if n, _ := r.Read(p); n != 16 {
-			return nil, errBadData
+			return nil, &ErrParse{Offset: offset, Field: "header", Err: errBadData}
 		}
 
 		version = p[0]
 		if version != 0 && version != '2' && version != '3' {
-			return nil, errUnsupportedVersion
+			return nil, &ErrParse{Offset: offset, Field: "version", Err: errUnsupportedVersion}
 		}
 
 		if v > version {
-			return nil, errBadData
+			return nil, &ErrParse{Offset: offset, Field: "version", Err: errBadData}
 		}
 
 		var hdr Header
+		offset = r.n
 		err := binary.Read(r, binary.BigEndian, &hdr)
 		if err != nil {
-			return nil, err
+			return nil, &ErrParse{Offset: offset, Field: "header", Err: err}
 		}
 
 		// skip uninteresting data:
@@ -146,8 +576,9 @@ func parseVx(r io.Reader) ([]LeapSecond, error) {
 			skip += int(hdr.LeapCnt)*8 + int(hdr.IsUtcCnt) + int(hdr.IsStdCnt)
 		}
 
+		offset = r.n
 		if n, _ := io.CopyN(io.Discard, r, int64(skip)); n != int64(skip) {
-			return nil, errBadData
+			return nil, &ErrParse{Offset: offset, Field: "data", Err: errBadData}
 		}
 
 		if v == 0 && version > 0 {
@@ -157,21 +588,32 @@ func parseVx(r io.Reader) ([]LeapSecond, error) {
 		// calculate the amount of bytes to skip after reading leap seconds array
 		skip = int(hdr.IsUtcCnt) + int(hdr.IsStdCnt)
 
+		// leap record is 4 (8 for version 2+) bytes of time followed by 4 bytes of count
+		record := make([]byte, 8)
+		if version > 0 {
+			record = make([]byte, 12)
+		}
+		// don't trust the header: the data must be able to hold all the records it claims.
+		// A partially present last record is reported by the loop below with its offset
+		if left, ok := r.remaining(); ok {
+			recordSize := int64(len(record))
+			if int64(hdr.LeapCnt) > (left+recordSize-1)/recordSize {
+				return nil, &ErrParse{Offset: r.n, Field: "leap count", Err: errBadData}
+			}
+			ret = make([]LeapSecond, 0, hdr.LeapCnt)
+		}
 		for i := 0; i < int(hdr.LeapCnt); i++ {
 			var l LeapSecond
+			offset = r.n
+			if _, err := io.ReadFull(r, record); err != nil {
+				return nil, &ErrParse{Offset: offset, Field: "leap record", Err: err}
+			}
 			if version == 0 {
-				lsv0 := []uint32{0, 0}
-				err := binary.Read(r, binary.BigEndian, &lsv0)
-				if err != nil {
-					return nil, err
-				}
-				l.Tleap = uint64(lsv0[0])
-				l.Nleap = int32(lsv0[1])
+				l.Tleap = uint64(binary.BigEndian.Uint32(record))
+				l.Nleap = int32(binary.BigEndian.Uint32(record[4:]))
 			} else {
-				err := binary.Read(r, binary.BigEndian, &l)
-				if err != nil {
-					return nil, err
-				}
+				l.Tleap = binary.BigEndian.Uint64(record)
+				l.Nleap = int32(binary.BigEndian.Uint32(record[8:]))
 			}
 			ret = append(ret, l)
 		}
@@ -208,12 +650,17 @@ func prepareHeader(ver byte, lsCnt int, name string) []byte {
 	return h.Bytes()
 }
 
-func writePreData(f io.Writer, name string) error {
+func writePreData(f io.Writer, name string, gmtOff int32, isDST bool) error {
 	// we have zero-sized transition times array - skip it
 
-	// one mandatory "local time type Record"
-	var sixZeros = []byte{0, 0, 0, 0, 0, 0}
-	if _, err := f.Write(sixZeros); err != nil {
+	// one mandatory "local time type Record":
+	// 4 bytes of UT offset, 1 byte of DST flag and 1 byte of abbreviation index
+	ttinfo := make([]byte, 6)
+	binary.BigEndian.PutUint32(ttinfo, uint32(gmtOff))
+	if isDST {
+		ttinfo[4] = 1
+	}
+	if _, err := f.Write(ttinfo); err != nil {
 		return err
 	}
 
@@ -232,12 +679,94 @@ func writePostData(f io.Writer) error {
 	return nil
 }
 
-// Write dumps arrays of leap seconds into file with newly created header
+// WriteOptions describe the local time type of the written file
+type WriteOptions struct {
+	// Name is the time zone abbreviation, UTC if empty
+	Name string
+	// GMTOff is the UT offset in seconds
+	GMTOff int32
+	// IsDST marks the local time type as daylight saving time
+	IsDST bool
+	// TZString is the POSIX TZ rule (like "UTC0") written to the footer of version 2+ files.
+	// It's derived from Name and GMTOff if empty
+	TZString string
+}
+
+// posixTZ returns POSIX TZ string for the footer of version 2+ files
+func (o WriteOptions) posixTZ() string {
+	if o.TZString != "" {
+		return o.TZString
+	}
+	if o.GMTOff == 0 {
+		return o.Name
+	}
+	// POSIX offset is the value to add to local time to get UTC, hence the sign flip
+	off := -o.GMTOff
+	sign := ""
+	if off < 0 {
+		sign = "-"
+		off = -off
+	}
+	h, m, sec := off/3600, off%3600/60, off%60
+	switch {
+	case sec != 0:
+		return fmt.Sprintf("<%s>%s%d:%02d:%02d", o.Name, sign, h, m, sec)
+	case m != 0:
+		return fmt.Sprintf("<%s>%s%d:%02d", o.Name, sign, h, m)
+	}
+	return fmt.Sprintf("<%s>%s%d", o.Name, sign, h)
+}
+
+// Write dumps arrays of leap seconds into file with newly created header.
+// ver is the TZif version byte: 0 (or '1') for a single 32-bit block, '2' or '3'
 func Write(f io.Writer, ver byte, ls []LeapSecond, name string) error {
-	if ver != 0 && ver != '2' {
+	return WriteWithOptions(f, ver, ls, WriteOptions{Name: name})
+}
+
+// validateLeapSeconds checks that leap seconds are in chronological order and the count changes by one each time.
+// The last record may repeat the count, which marks the expiration time
+func validateLeapSeconds(ls []LeapSecond) error {
+	var prev LeapSecond
+	for i, l := range ls {
+		if i > 0 && l.Tleap <= prev.Tleap {
+			return fmt.Errorf("%w: leap second %d at %d is not after %d", errLeapOrder, i, l.Tleap, prev.Tleap)
+		}
+		step := l.Nleap - prev.Nleap
+		expiration := step == 0 && i > 0 && i == len(ls)-1
+		if step != 1 && step != -1 && !expiration {
+			return fmt.Errorf("%w: leap second %d changes count from %d to %d", errLeapOrder, i, prev.Nleap, l.Nleap)
+		}
+		prev = l
+	}
+	return nil
+}
+
+// WriteWithOptions dumps arrays of leap seconds into file with newly created header
+// and local time type described by opts
+func WriteWithOptions(f io.Writer, ver byte, ls []LeapSecond, opts WriteOptions) error {
+	// there is no '1' on the wire, version 1 files have zero version byte
+	if ver == '1' {
+		ver = 0
+	}
+	if ver != 0 && ver != '2' && ver != '3' {
 		return errUnsupportedVersion
 	}
+	if err := validateLeapSeconds(ls); err != nil {
+		return err
+	}
+	if err := validateTZString(opts.TZString); err != nil {
+		return err
+	}
+	if ver == 0 {
+		// version 1 block has 32-bit signed transition times
+		for i, l := range ls {
+			if l.Tleap > math.MaxInt32 {
+				return fmt.Errorf("%w: leap second %d at %d", errV1Range, i, l.Tleap)
+			}
+		}
+	}
 
+	name := opts.Name
 	var nameFormatted string
 	if name == "" {
 		nameFormatted = "UTC\x00"
@@ -245,7 +774,7 @@ func Write(f io.Writer, ver byte, ls []LeapSecond, name string) error {
 		nameFormatted = name + "\x00"
 	}
 
-	// prepare header which will be reused in case of version 2
+	// prepare header which will be reused in case of version 2 or 3
 	hdr := prepareHeader(ver, len(ls), nameFormatted)
 
 	// Write prepared header
@@ -254,7 +783,7 @@ func Write(f io.Writer, ver byte, ls []LeapSecond, name string) error {
 	}
 
 	// data before array of leap seconds
-	if err := writePreData(f, nameFormatted); err != nil {
+	if err := writePreData(f, nameFormatted, opts.GMTOff, opts.IsDST); err != nil {
 		return err
 	}
 
@@ -271,18 +800,18 @@ func Write(f io.Writer, ver byte, ls []LeapSecond, name string) error {
 		return err
 	}
 
-	if ver != '2' {
+	if ver == 0 {
 		return nil
 	}
 
-	// now we have to write version 2 part of file
+	// now we have to write version 2 (or 3, which has the same layout) part of file
 	// prepared header could be reused
 	if _, err := f.Write(hdr); err != nil {
 		return err
 	}
 
 	// data before array of leap seconds
-	if err := writePreData(f, nameFormatted); err != nil {
+	if err := writePreData(f, nameFormatted, opts.GMTOff, opts.IsDST); err != nil {
 		return err
 	}
 
@@ -301,7 +830,7 @@ func Write(f io.Writer, ver byte, ls []LeapSecond, name string) error {
 
 	// and now we have to write POSIZ TZ string along with new line separators
 	// usually it's the same string as in the header
-	posixTz := "\n" + name + "\n"
+	posixTz := "\n" + opts.posixTZ() + "\n"
 	if _, err := io.WriteString(f, posixTz); err != nil {
 		return err
 	}
@@ -19,7 +19,10 @@ package leapsectz
 import (
 	"bytes"
 	"encoding/binary"
+	"math"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
@@ -79,6 +82,64 @@ var tzV2 = []byte{
 	'T', 'C', 0x0a, // 2 bytes of UTC/STD
 }
 
+// tzV2Populated is TZif version 2 data with a populated version 1 block,
+// like the real zoneinfo files have: transitions, local time types and designations
+var tzV2Populated = []byte{
+	'T', 'Z', 'i', 'f', // magic
+	'2', 0x00, 0x00, 0x00, // version
+	0x00, 0x00, 0x00, 0x00, // pad
+	0x00, 0x00, 0x00, 0x00, // pad
+	0x00, 0x00, 0x00, 0x00, // pad
+	0x00, 0x00, 0x00, 0x02, // UTC/local
+	0x00, 0x00, 0x00, 0x02, // standard/wall
+	0x00, 0x00, 0x00, 0x02, // leap
+	0x00, 0x00, 0x00, 0x02, // transition
+	0x00, 0x00, 0x00, 0x02, // local tz
+	0x00, 0x00, 0x00, 0x08, // characters
+	0x80, 0x00, 0x00, 0x00, // transition time
+	0x00, 0x00, 0x00, 0x00, // transition time
+	0x00, 0x01, // transition types
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // local time type: UT offset, isdst, designation index
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x04, // local time type: UT offset, isdst, designation index
+	'L', 'M', 'T', 0x00, // designation
+	'U', 'T', 'C', 0x00, // designation
+	0x04, 0xb2, 0x58, 0x00, // leap time
+	0x00, 0x00, 0x00, 0x01, // leap count
+	0x05, 0xa4, 0xec, 0x01, // leap time
+	0x00, 0x00, 0x00, 0x02, // leap count
+	0x00, 0x01, // standard/wall indicators
+	0x00, 0x01, // UTC/local indicators
+	'T', 'Z', 'i', 'f', // magic
+	'2', 0x00, 0x00, 0x00, // version
+	0x00, 0x00, 0x00, 0x00, // pad
+	0x00, 0x00, 0x00, 0x00, // pad
+	0x00, 0x00, 0x00, 0x00, // pad
+	0x00, 0x00, 0x00, 0x02, // UTC/local
+	0x00, 0x00, 0x00, 0x02, // standard/wall
+	0x00, 0x00, 0x00, 0x02, // leap
+	0x00, 0x00, 0x00, 0x02, // transition
+	0x00, 0x00, 0x00, 0x02, // local tz
+	0x00, 0x00, 0x00, 0x08, // characters
+	0xff, 0xff, 0xff, 0xff, // transition time (first 32 bits)
+	0x80, 0x00, 0x00, 0x00, // transition time (last 32 bits)
+	0x00, 0x00, 0x00, 0x00, // transition time (first 32 bits)
+	0x00, 0x00, 0x00, 0x00, // transition time (last 32 bits)
+	0x00, 0x01, // transition types
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // local time type: UT offset, isdst, designation index
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x04, // local time type: UT offset, isdst, designation index
+	'L', 'M', 'T', 0x00, // designation
+	'U', 'T', 'C', 0x00, // designation
+	0x00, 0x00, 0x00, 0x00, // leap time (first 32 bits)
+	0x04, 0xb2, 0x58, 0x00, // leap time (last 32 bits)
+	0x00, 0x00, 0x00, 0x01, // leap count
+	0x00, 0x00, 0x00, 0x00, // leap time (first 32 bits)
+	0x05, 0xa4, 0xec, 0x01, // leap time (last 32 bits)
+	0x00, 0x00, 0x00, 0x02, // leap count
+	0x00, 0x01, // standard/wall indicators
+	0x00, 0x01, // UTC/local indicators
+	0x0a, 'U', 'T', 'C', '0', 0x0a, // footer
+}
+
 func TestParseV1(t *testing.T) {
 	r := bytes.NewReader(tz)
 
@@ -110,6 +171,34 @@ func TestParseV2(t *testing.T) {
 	require.Equal(t, int32(2), ls[1].Nleap)
 }
 
+func TestParseV3(t *testing.T) {
+	// version 3 has the same leap seconds layout as version 2
+	tzV3 := append([]byte{}, tzV2...)
+	tzV3[4] = '3'
+	// second header follows the version 1 data block
+	tzV3[44+2*8+4] = '3'
+
+	ls, err := parseVx(bytes.NewReader(tzV3))
+	require.NoError(t, err)
+	require.Equal(t, []LeapSecond{{78796800, 1}, {94694401, 2}}, ls)
+}
+
+func TestWriteV3(t *testing.T) {
+	ls := []LeapSecond{
+		{78796800, 1},
+		{94694401, 2},
+	}
+
+	var b bytes.Buffer
+	err := Write(&b, '3', ls, "UTC")
+	require.NoError(t, err)
+	require.Equal(t, byte('3'), b.Bytes()[4])
+
+	parsed, err := parseVx(&b)
+	require.NoError(t, err)
+	require.Equal(t, ls, parsed)
+}
+
 func TestParseV2Fail(t *testing.T) {
 	tzNoLeapInfo := []byte{
 		'T', 'Z', 'i', 'f', // magic
@@ -138,7 +227,7 @@ func TestParseV2Fail(t *testing.T) {
 
 	r := bytes.NewReader(tzNoLeapInfo)
 	ls, err := parseVx(r)
-	require.ErrorIs(t, errNoLeapSeconds, err)
+	require.ErrorIs(t, err, errNoLeapSeconds)
 	require.Equal(t, 0, len(ls))
 }
 
@@ -156,10 +245,115 @@ func TestParse(t *testing.T) {
 	err = f.Close()
 	require.NoError(t, err)
 
+	defer func(orig string) { leapFile = orig }(leapFile)
 	leapFile = f.Name()
 	ls, err := Parse("")
 	require.NoError(t, err)
 	require.ElementsMatch(t, expected, ls)
+
+	ls, err = Parse(f.Name())
+	require.NoError(t, err)
+	require.ElementsMatch(t, expected, ls)
+}
+
+func TestParseFrom(t *testing.T) {
+	f, err := os.CreateTemp(os.TempDir(), "leaptest-")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+
+	_, err = f.Write(tzV2)
+	require.NoError(t, err)
+	err = f.Close()
+	require.NoError(t, err)
+
+	ls, err := ParseFrom(f.Name())
+	require.NoError(t, err)
+	require.Equal(t, []LeapSecond{{78796800, 1}, {94694401, 2}}, ls)
+
+	_, err = ParseFrom("")
+	require.Error(t, err)
+	_, err = ParseFrom(filepath.Join(t.TempDir(), "missing"))
+	require.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestParseBytes(t *testing.T) {
+	ls, err := ParseBytes(tzV2)
+	require.NoError(t, err)
+	require.Equal(t, []LeapSecond{{78796800, 1}, {94694401, 2}}, ls)
+
+	ls, err = ParseBytes(tz)
+	require.NoError(t, err)
+	require.Equal(t, []LeapSecond{{78796800, 1}}, ls)
+
+	_, err = ParseBytes(tzV2[:10])
+	require.ErrorIs(t, err, errBadData)
+}
+
+func TestVersion(t *testing.T) {
+	v, err := Version(writeTemp(t, tzV2))
+	require.NoError(t, err)
+	require.Equal(t, byte('2'), v)
+
+	v, err = Version(writeTemp(t, tz))
+	require.NoError(t, err)
+	require.Equal(t, byte(0), v)
+
+	_, err = Version(writeTemp(t, []byte("TZ")))
+	require.ErrorIs(t, err, errBadData)
+
+	bad := append([]byte{}, tz...)
+	bad[4] = '9'
+	_, err = Version(writeTemp(t, bad))
+	require.ErrorIs(t, err, errUnsupportedVersion)
+
+	_, err = Version("/does/not/exist")
+	require.Error(t, err)
+}
+
+func TestParseWithOffsets(t *testing.T) {
+	f, err := os.CreateTemp(os.TempDir(), "leaptest-")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+
+	_, err = f.Write(tzV2)
+	require.NoError(t, err)
+	err = f.Close()
+	require.NoError(t, err)
+
+	entries, err := ParseWithOffsets(f.Name())
+	require.NoError(t, err)
+	require.Equal(t, 2, len(entries))
+
+	require.Equal(t, LeapSecond{78796800, 1}, entries[0].LeapSecond)
+	require.Equal(t, int32(1), entries[0].Step)
+	require.Equal(t, int32(1), entries[0].Offset)
+	require.Equal(t, 11*time.Second, entries[0].TAIOffset())
+
+	require.Equal(t, LeapSecond{94694401, 2}, entries[1].LeapSecond)
+	require.Equal(t, int32(1), entries[1].Step)
+	require.Equal(t, int32(2), entries[1].Offset)
+	require.Equal(t, 12*time.Second, entries[1].TAIOffset())
+
+	_, err = ParseWithOffsets("/does/not/exist")
+	require.Error(t, err)
+}
+
+func TestOffsetAt(t *testing.T) {
+	ls, err := parseVx(bytes.NewReader(tzV2))
+	require.NoError(t, err)
+
+	first := time.Date(1972, time.July, 1, 0, 0, 0, 0, time.UTC)
+	second := time.Date(1973, time.January, 1, 0, 0, 0, 0, time.UTC)
+	require.Equal(t, first, ls[0].Time().UTC())
+	require.Equal(t, second, ls[1].Time().UTC())
+
+	require.Equal(t, int32(0), OffsetAt(ls, time.Date(1970, time.January, 1, 0, 0, 0, 0, time.UTC)))
+	require.Equal(t, int32(0), OffsetAt(ls, first.Add(-time.Second)))
+	require.Equal(t, int32(1), OffsetAt(ls, first))
+	require.Equal(t, int32(1), OffsetAt(ls, second.Add(-time.Nanosecond)))
+	require.Equal(t, int32(2), OffsetAt(ls, second))
+	require.Equal(t, int32(2), OffsetAt(ls, time.Date(2022, time.January, 1, 0, 0, 0, 0, time.UTC)))
+	require.Equal(t, int32(0), OffsetAt(nil, second))
 }
 
 func TestLatest(t *testing.T) {
@@ -178,6 +372,113 @@ func TestLatest(t *testing.T) {
 	require.Equal(t, expected, ls)
 }
 
+func TestAppendLeapSecond(t *testing.T) {
+	f, err := os.CreateTemp(t.TempDir(), "leaptest-")
+	require.NoError(t, err)
+	err = Write(f, '2', []LeapSecond{{78796800, 1}, {94694401, 2}}, "UTC")
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	require.NoError(t, os.Chmod(f.Name(), 0644))
+
+	// January 1, 1974
+	err = AppendLeapSecond(f.Name(), LeapSecond{126230402, 3})
+	require.NoError(t, err)
+	st, err := os.Stat(f.Name())
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0644), st.Mode().Perm())
+
+	ls, err := Parse(f.Name())
+	require.NoError(t, err)
+	require.Equal(t, []LeapSecond{{78796800, 1}, {94694401, 2}, {126230402, 3}}, ls)
+	b, err := os.ReadFile(f.Name())
+	require.NoError(t, err)
+	require.Equal(t, byte('2'), b[4])
+
+	// negative one
+	err = AppendLeapSecond(f.Name(), LeapSecond{157766403, 2})
+	require.NoError(t, err)
+
+	err = AppendLeapSecond(f.Name(), LeapSecond{157766403, 3})
+	require.ErrorIs(t, err, errDuplicateLeap)
+
+	err = AppendLeapSecond(f.Name(), LeapSecond{94694401, 1})
+	require.ErrorIs(t, err, errLeapOrder)
+
+	err = AppendLeapSecond(f.Name(), LeapSecond{189302400, 4})
+	require.ErrorIs(t, err, errLeapOrder)
+
+	// failed attempts leave the file intact
+	ls, err = Parse(f.Name())
+	require.NoError(t, err)
+	require.Equal(t, 4, len(ls))
+
+	err = AppendLeapSecond("/does/not/exist", LeapSecond{126230402, 3})
+	require.Error(t, err)
+}
+
+func TestAppendLeapSecondKeepsData(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "right-UTC")
+	require.NoError(t, os.WriteFile(path, tzV2Populated, 0644))
+
+	// January 1, 1974
+	err := AppendLeapSecond(path, LeapSecond{126230402, 3})
+	require.NoError(t, err)
+	b, err := os.ReadFile(path)
+	require.NoError(t, err)
+	ls, footer, err := ParseWithTZString(bytes.NewReader(b))
+	require.NoError(t, err)
+	require.Equal(t, []LeapSecond{{78796800, 1}, {94694401, 2}, {126230402, 3}}, ls)
+	require.Equal(t, "UTC0", footer)
+
+	// one more record in each block, transitions, local time types and designations are untouched
+	require.Equal(t, len(tzV2Populated)+8+12, len(b))
+	require.Equal(t, 2, bytes.Count(b, []byte("LMT\x00UTC\x00")))
+	require.True(t, bytes.Contains(b, []byte{0xff, 0xff, 0xff, 0xff, 0x80, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01}))
+	require.Equal(t, []byte{0x00, 0x00, 0x00, 0x03}, b[28:32])
+}
+
+func TestAppendLeapSecondSameAsWrite(t *testing.T) {
+	opts := WriteOptions{Name: "TAI", GMTOff: 3600, TZString: "<TAI>-1"}
+	for _, ver := range []byte{0, '2', '3'} {
+		var buf bytes.Buffer
+		require.NoError(t, WriteWithOptions(&buf, ver, []LeapSecond{{78796800, 1}, {94694401, 2}}, opts))
+		path := filepath.Join(t.TempDir(), "leap")
+		require.NoError(t, os.WriteFile(path, buf.Bytes(), 0644))
+		require.NoError(t, AppendLeapSecond(path, LeapSecond{126230402, 3}))
+
+		buf.Reset()
+		require.NoError(t, WriteWithOptions(&buf, ver, []LeapSecond{{78796800, 1}, {94694401, 2}, {126230402, 3}}, opts))
+		b, err := os.ReadFile(path)
+		require.NoError(t, err)
+		require.Equal(t, buf.Bytes(), b, "version %q", ver)
+	}
+}
+
+func TestAppendLeapSecondV1Range(t *testing.T) {
+	dir := t.TempDir()
+	ls := []LeapSecond{{78796800, 1}}
+	next := LeapSecond{math.MaxInt32 + 1, 2}
+
+	var buf bytes.Buffer
+	require.NoError(t, Write(&buf, 0, ls, "UTC"))
+	path := filepath.Join(dir, "v1")
+	require.NoError(t, os.WriteFile(path, buf.Bytes(), 0644))
+	require.ErrorIs(t, AppendLeapSecond(path, next), errV1Range)
+
+	// version 1 block of version 2 data goes without it
+	buf.Reset()
+	require.NoError(t, Write(&buf, '2', ls, "UTC"))
+	path = filepath.Join(dir, "v2")
+	require.NoError(t, os.WriteFile(path, buf.Bytes(), 0644))
+	require.NoError(t, AppendLeapSecond(path, next))
+	got, err := Parse(path)
+	require.NoError(t, err)
+	require.Equal(t, append(ls, next), got)
+	b, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, []byte{0, 0, 0, 1}, b[28:32])
+}
+
 func TestLatestFuture(t *testing.T) {
 	expected := &LeapSecond{1649346026, 2}
 
@@ -199,6 +500,133 @@ func TestLatestFuture(t *testing.T) {
 	require.Equal(t, expected, latest)
 }
 
+func TestLatestBefore(t *testing.T) {
+	ls := []LeapSecond{
+		{1649346016, 1},
+		{1649346026, 2},
+		{2649346018, 3},
+	}
+
+	f, err := os.CreateTemp(os.TempDir(), "leaptest-")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+
+	err = Write(f, '2', ls, "UTC")
+	require.NoError(t, err)
+
+	// past cutoff
+	latest, err := LatestBefore(f.Name(), time.Unix(1649346020, 0))
+	require.NoError(t, err)
+	require.Equal(t, &LeapSecond{1649346016, 1}, latest)
+
+	// exactly at the leap second
+	latest, err = LatestBefore(f.Name(), time.Unix(1649346025, 0))
+	require.NoError(t, err)
+	require.Equal(t, &LeapSecond{1649346026, 2}, latest)
+
+	// future cutoff includes the future leap second
+	latest, err = LatestBefore(f.Name(), time.Unix(2649346016, 0))
+	require.NoError(t, err)
+	require.Equal(t, &LeapSecond{2649346018, 3}, latest)
+
+	// before all of them
+	latest, err = LatestBefore(f.Name(), time.Unix(0, 0))
+	require.NoError(t, err)
+	require.Equal(t, &LeapSecond{}, latest)
+
+	_, err = LatestBefore("/does/not/exist", time.Now())
+	require.Error(t, err)
+}
+
+func TestNextLeap(t *testing.T) {
+	ls := []LeapSecond{
+		{1649346016, 1},
+		{1649346026, 2},
+		{2649346018, 3},
+	}
+
+	future := time.Unix(2649346016, 0)
+	require.Equal(t, future, ls[2].Time())
+
+	next, until := NextLeap(ls, future.Add(-time.Hour))
+	require.Equal(t, &LeapSecond{2649346018, 3}, next)
+	require.Equal(t, time.Hour, until)
+
+	// the earliest of the future ones
+	next, until = NextLeap(ls, time.Unix(1649346000, 0))
+	require.Equal(t, &LeapSecond{1649346016, 1}, next)
+	require.Equal(t, 16*time.Second, until)
+
+	next, until = NextLeap(ls, future)
+	require.Nil(t, next)
+	require.Equal(t, time.Duration(0), until)
+}
+
+// tzNegative has a negative leap second on January 1, 1974 after two positive ones
+var tzNegative = []LeapSecond{
+	{78796800, 1},
+	{94694401, 2},
+	{126230402, 1},
+}
+
+func TestLatestNegative(t *testing.T) {
+	f, err := os.CreateTemp(os.TempDir(), "leaptest-")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+
+	err = Write(f, '2', tzNegative, "UTC")
+	require.NoError(t, err)
+
+	ls, err := Parse(f.Name())
+	require.NoError(t, err)
+	require.Equal(t, tzNegative, ls)
+
+	latest, err := Latest(f.Name())
+	require.NoError(t, err)
+	require.Equal(t, &LeapSecond{126230402, 1}, latest)
+}
+
+func TestWriteV1Negative(t *testing.T) {
+	var b bytes.Buffer
+	err := Write(&b, 0, tzNegative, "UTC")
+	require.NoError(t, err)
+
+	ls, err := parseVx(&b)
+	require.NoError(t, err)
+	require.Equal(t, tzNegative, ls)
+}
+
+func TestOffsetAtNegative(t *testing.T) {
+	negative := time.Date(1974, time.January, 1, 0, 0, 0, 0, time.UTC)
+	require.Equal(t, negative, leapTime(tzNegative, 2).UTC())
+	require.Equal(t, int32(2), OffsetAt(tzNegative, negative.Add(-time.Second)))
+	require.Equal(t, int32(1), OffsetAt(tzNegative, negative))
+
+	next, until := NextLeap(tzNegative, negative.Add(-time.Minute))
+	require.Equal(t, &LeapSecond{126230402, 1}, next)
+	require.Equal(t, time.Minute, until)
+
+	f, err := os.CreateTemp(os.TempDir(), "leaptest-")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+	err = Write(f, '2', tzNegative, "UTC")
+	require.NoError(t, err)
+	entries, err := ParseWithOffsets(f.Name())
+	require.NoError(t, err)
+	require.Equal(t, int32(-1), entries[2].Step)
+	require.Equal(t, 11*time.Second, entries[2].TAIOffset())
+}
+
+func TestLeapTime(t *testing.T) {
+	ls := []LeapSecond{
+		{78796800, 1},
+		{94694401, 2},
+	}
+	for i := range ls {
+		require.Equal(t, ls[i].Time(), leapTime(ls, i))
+	}
+}
+
 func TestPrepareHeader(t *testing.T) {
 	byteData := []byte{
 		'T', 'Z', 'i', 'f', // magic
@@ -225,11 +653,121 @@ func TestWritePreData(t *testing.T) {
 		'C', 0x00,
 	}
 	var b bytes.Buffer
-	err := writePreData(&b, "UTC\x00")
+	err := writePreData(&b, "UTC\x00", 0, false)
+	require.NoError(t, err)
+	require.Equal(t, byteData, b.Bytes())
+}
+
+func TestWritePreDataOffset(t *testing.T) {
+	byteData := []byte{
+		0x00, 0x00, 0x1c, 0x20, // UT offset of 2 hours
+		0x01,                     // DST
+		0x00,                     // abbreviation index
+		'C', 'E', 'S', 'T', 0x00, // abbreviation
+	}
+	var b bytes.Buffer
+	err := writePreData(&b, "CEST\x00", 7200, true)
+	require.NoError(t, err)
+	require.Equal(t, byteData, b.Bytes())
+
+	byteData = []byte{
+		0xff, 0xff, 0xb9, 0xb0, // UT offset of -5 hours
+		0x00,                // no DST
+		0x00,                // abbreviation index
+		'E', 'S', 'T', 0x00, // abbreviation
+	}
+	b.Reset()
+	err = writePreData(&b, "EST\x00", -18000, false)
 	require.NoError(t, err)
 	require.Equal(t, byteData, b.Bytes())
 }
 
+func TestWriteWithOptions(t *testing.T) {
+	ls := []LeapSecond{{78796800, 1}}
+	var b bytes.Buffer
+	err := WriteWithOptions(&b, '2', ls, WriteOptions{Name: "CET", GMTOff: 3600})
+	require.NoError(t, err)
+
+	// ttinfo of the version 2 block
+	ttinfo := []byte{0x00, 0x00, 0x0e, 0x10, 0x00, 0x00, 'C', 'E', 'T', 0x00}
+	v2 := b.Bytes()[64:]
+	require.Equal(t, ttinfo, v2[44:54])
+	require.True(t, bytes.HasSuffix(b.Bytes(), []byte("\n<CET>-1\n")))
+
+	parsed, err := parseVx(&b)
+	require.NoError(t, err)
+	require.Equal(t, ls, parsed)
+
+	// matches Write for UTC
+	var utc bytes.Buffer
+	err = Write(&utc, '2', ls, "UTC")
+	require.NoError(t, err)
+	b.Reset()
+	err = WriteWithOptions(&b, '2', ls, WriteOptions{Name: "UTC"})
+	require.NoError(t, err)
+	require.Equal(t, utc.Bytes(), b.Bytes())
+}
+
+func TestPosixTZ(t *testing.T) {
+	require.Equal(t, "UTC", WriteOptions{Name: "UTC"}.posixTZ())
+	require.Equal(t, "<CET>-1", WriteOptions{Name: "CET", GMTOff: 3600}.posixTZ())
+	require.Equal(t, "<EST>5", WriteOptions{Name: "EST", GMTOff: -18000}.posixTZ())
+	require.Equal(t, "<+0530>-5:30", WriteOptions{Name: "+0530", GMTOff: 19800}.posixTZ())
+	require.Equal(t, "<LMT>-0:00:10", WriteOptions{Name: "LMT", GMTOff: 10}.posixTZ())
+}
+
+func TestWriteTZString(t *testing.T) {
+	ls := []LeapSecond{{78796800, 1}, {94694401, 2}}
+	var b bytes.Buffer
+	err := WriteWithOptions(&b, '2', ls, WriteOptions{Name: "UTC", TZString: "UTC0"})
+	require.NoError(t, err)
+	require.True(t, bytes.HasSuffix(b.Bytes(), []byte("\nUTC0\n")))
+
+	got, rule, err := ParseWithTZString(bytes.NewReader(b.Bytes()))
+	require.NoError(t, err)
+	require.Equal(t, ls, got)
+	require.Equal(t, "UTC0", rule)
+
+	// derived from the name by default
+	b.Reset()
+	require.NoError(t, WriteWithOptions(&b, '3', ls, WriteOptions{Name: "CET", GMTOff: 3600}))
+	_, rule, err = ParseWithTZString(bytes.NewReader(b.Bytes()))
+	require.NoError(t, err)
+	require.Equal(t, "<CET>-1", rule)
+
+	// newline would break the footer
+	err = WriteWithOptions(&b, '2', ls, WriteOptions{TZString: "UTC\n0"})
+	require.ErrorIs(t, err, errBadTZString)
+}
+
+func TestParseWithTZString(t *testing.T) {
+	// version 1 has no footer
+	ls, rule, err := ParseWithTZString(bytes.NewReader(tz))
+	require.NoError(t, err)
+	require.Equal(t, []LeapSecond{{78796800, 1}}, ls)
+	require.Equal(t, "", rule)
+
+	ls, rule, err = ParseWithTZString(bytes.NewReader(tzV2Populated))
+	require.NoError(t, err)
+	require.Equal(t, []LeapSecond{{78796800, 1}, {94694401, 2}}, ls)
+	require.Equal(t, "UTC0", rule)
+
+	// empty rule is allowed
+	data := append([]byte{}, tzV2Populated[:len(tzV2Populated)-6]...)
+	_, rule, err = ParseWithTZString(bytes.NewReader(append(data, '\n', '\n')))
+	require.NoError(t, err)
+	require.Equal(t, "", rule)
+
+	for _, footer := range []string{"UTC0", "\nUTC0", "UTC0\n", "\n", "\nUT\nC0\n", "\nUTC\x000\n", "\n" + strings.Repeat("U", maxFooterSize) + "\n"} {
+		_, _, err = ParseWithTZString(bytes.NewReader(append(append([]byte{}, data...), footer...)))
+		require.ErrorIs(t, err, errBadTZString, "footer %q", footer)
+		var perr *ErrParse
+		require.ErrorAs(t, err, &perr)
+		require.Equal(t, "footer", perr.Field)
+		require.Equal(t, len(data), perr.Offset)
+	}
+}
+
 func TestWritePostData(t *testing.T) {
 	byteData := []byte{0x00, 0x00}
 	var b bytes.Buffer
@@ -244,6 +782,19 @@ func TestLeapSecondStructure(t *testing.T) {
 	require.Equal(t, tt, l.Time().UTC())
 }
 
+func TestLeapSecondStructureNTP(t *testing.T) {
+	l := LeapSecond{2287785600, 1}
+	tt := time.Date(1972, time.July, 1, 0, 0, 0, 0, time.UTC)
+	require.Equal(t, tt, l.TimeNTP().UTC())
+
+	l = LeapSecond{2303683200, 2}
+	tt = time.Date(1973, time.January, 1, 0, 0, 0, 0, time.UTC)
+	require.Equal(t, tt, l.TimeNTP().UTC())
+
+	// same event in TZif convention
+	require.Equal(t, LeapSecond{94694401, 2}.Time(), l.TimeNTP())
+}
+
 func TestParserWrongHeaderMagicString(t *testing.T) {
 	byteData := []byte{
 		'T', 'Z', 'v', '2', // magic
@@ -264,7 +815,7 @@ func TestParserWrongHeaderMagicString(t *testing.T) {
 	r := bytes.NewReader(byteData)
 
 	_, err := parseVx(r)
-	require.ErrorIs(t, errBadData, err)
+	require.ErrorIs(t, err, errBadData)
 }
 
 func TestParserWrongHeaderPadding(t *testing.T) {
@@ -278,7 +829,7 @@ func TestParserWrongHeaderPadding(t *testing.T) {
 	r := bytes.NewReader(byteData)
 
 	_, err := parseVx(r)
-	require.ErrorIs(t, errBadData, err)
+	require.ErrorIs(t, err, errBadData)
 }
 
 func TestParserWrongHeaderVersion(t *testing.T) {
@@ -293,7 +844,32 @@ func TestParserWrongHeaderVersion(t *testing.T) {
 	r := bytes.NewReader(byteData)
 
 	_, err := parseVx(r)
-	require.ErrorIs(t, errUnsupportedVersion, err)
+	require.ErrorIs(t, err, errUnsupportedVersion)
+}
+
+func TestParserErrorOffset(t *testing.T) {
+	// truncated right after the magic
+	_, err := parseVx(bytes.NewReader([]byte("TZif")))
+	var perr *ErrParse
+	require.ErrorAs(t, err, &perr)
+	require.Equal(t, "header", perr.Field)
+	require.Equal(t, 4, perr.Offset)
+	require.ErrorIs(t, err, errBadData)
+	require.Equal(t, "malformed time zone information: header at offset 4", err.Error())
+
+	// truncated in the middle of the second leap record
+	_, err = parseVx(bytes.NewReader(tz[:len(tz)-2]))
+	require.ErrorAs(t, err, &perr)
+	require.Equal(t, "leap record", perr.Field)
+	require.Equal(t, 44, perr.Offset)
+
+	// second magic is broken
+	broken := append([]byte{}, tzV2...)
+	broken[60] = 'X'
+	_, err = parseVx(bytes.NewReader(broken))
+	require.ErrorAs(t, err, &perr)
+	require.Equal(t, "magic", perr.Field)
+	require.Equal(t, 60, perr.Offset)
 }
 
 func TestReadHeaderStruct(t *testing.T) {
@@ -369,16 +945,166 @@ func TestWriteV2(t *testing.T) {
 	require.Equal(t, byteData, b.Bytes())
 }
 
+func TestWriteValidation(t *testing.T) {
+	var b bytes.Buffer
+	// out of order
+	err := Write(&b, '2', []LeapSecond{{94694401, 2}, {78796800, 1}}, "UTC")
+	require.ErrorIs(t, err, errLeapOrder)
+	require.Equal(t, 0, b.Len())
+
+	// count jumps
+	err = Write(&b, '2', []LeapSecond{{78796800, 1}, {94694402, 3}}, "UTC")
+	require.ErrorIs(t, err, errLeapOrder)
+
+	// first one must start from zero
+	err = Write(&b, 0, []LeapSecond{{78796800, 5}}, "UTC")
+	require.ErrorIs(t, err, errLeapOrder)
+
+	// duplicate time
+	err = Write(&b, '2', []LeapSecond{{78796800, 1}, {78796800, 2}}, "UTC")
+	require.ErrorIs(t, err, errLeapOrder)
+
+	// repeated count is only allowed as the expiration record at the end
+	err = Write(&b, '2', []LeapSecond{{78796800, 1}, {94694401, 1}, {126230402, 2}}, "UTC")
+	require.ErrorIs(t, err, errLeapOrder)
+	err = Write(&b, '2', []LeapSecond{{78796800, 1}, {94694401, 1}}, "UTC")
+	require.NoError(t, err)
+
+	// valid one including a negative leap second
+	b.Reset()
+	err = Write(&b, '2', tzNegative, "UTC")
+	require.NoError(t, err)
+	ls, err := parseVx(&b)
+	require.NoError(t, err)
+	require.Equal(t, tzNegative, ls)
+}
+
+func TestWriteV1(t *testing.T) {
+	ls := []LeapSecond{{78796800, 1}, {94694401, 2}, {126230402, 3}}
+	for _, ver := range []byte{0, '1'} {
+		var b bytes.Buffer
+		require.NoError(t, Write(&b, ver, ls, "UTC"))
+
+		data := b.Bytes()
+		require.Equal(t, byte(0), data[4])
+		// header, one local time type, "UTC\x00", 3 leap records and 2 indicators, no second block
+		require.Equal(t, 44+6+4+3*8+2, len(data))
+
+		v, err := Version(writeTemp(t, data))
+		require.NoError(t, err)
+		require.Equal(t, byte(0), v)
+
+		got, err := parseVx(bytes.NewReader(data))
+		require.NoError(t, err)
+		require.Equal(t, ls, got)
+	}
+}
+
+func TestWriteV1Range(t *testing.T) {
+	var b bytes.Buffer
+	err := Write(&b, 0, []LeapSecond{{1 << 31, 1}}, "UTC")
+	require.ErrorIs(t, err, errV1Range)
+
+	// fine for version 2
+	require.NoError(t, Write(&b, '2', []LeapSecond{{1 << 31, 1}}, "UTC"))
+}
+
 func TestWriteWrongVersion(t *testing.T) {
 	var b bytes.Buffer
 	err := Write(&b, '4', []LeapSecond{}, "UTC")
-	require.ErrorIs(t, errUnsupportedVersion, err)
+	require.ErrorIs(t, err, errUnsupportedVersion)
+}
+
+func TestParseReader(t *testing.T) {
+	ls, err := ParseReader(bytes.NewReader(tzV2))
+	require.NoError(t, err)
+	require.Equal(t, []LeapSecond{{78796800, 1}, {94694401, 2}}, ls)
+
+	// version 1
+	ls, err = ParseReader(bytes.NewReader(tz))
+	require.NoError(t, err)
+	require.Equal(t, []LeapSecond{{78796800, 1}}, ls)
+
+	_, err = ParseReader(bytes.NewReader(tzV2[:10]))
+	require.ErrorIs(t, err, errBadData)
+
+	// offsets are still reported relative to the start of the data
+	broken := append([]byte{}, tzV2...)
+	broken[60] = 'X'
+	_, err = ParseReader(bytes.NewReader(broken))
+	var perr *ErrParse
+	require.ErrorAs(t, err, &perr)
+	require.Equal(t, "magic", perr.Field)
+	require.Equal(t, 60, perr.Offset)
+}
+
+func TestParseV2PopulatedV1Block(t *testing.T) {
+	want := []LeapSecond{{78796800, 1}, {94694401, 2}}
+
+	ls, err := parseVx(bytes.NewReader(tzV2Populated))
+	require.NoError(t, err)
+	require.Equal(t, want, ls)
+
+	ls, err = ParseReader(bytes.NewReader(tzV2Populated))
+	require.NoError(t, err)
+	require.Equal(t, want, ls)
+}
+
+// hugeLeapCnt returns 60 bytes of TZif version 1 data claiming a billion leap records
+func hugeLeapCnt() []byte {
+	b := make([]byte, 60)
+	copy(b, tz)
+	binary.BigEndian.PutUint32(b[28:], 1000000000)
+	return b
+}
+
+func TestParseHugeLeapCnt(t *testing.T) {
+	_, err := parseVx(bytes.NewReader(hugeLeapCnt()))
+	require.ErrorIs(t, err, errBadData)
+	var perr *ErrParse
+	require.ErrorAs(t, err, &perr)
+	require.Equal(t, "leap count", perr.Field)
+
+	_, err = ParseReader(bytes.NewReader(hugeLeapCnt()))
+	require.ErrorIs(t, err, errBadData)
+
+	// same when reading from file
+	name := writeTemp(t, hugeLeapCnt())
+	_, err = ParseFrom(name)
+	require.ErrorIs(t, err, errBadData)
+}
+
+// bigTZ returns TZif version 2 data with n leap seconds
+func bigTZ(tb testing.TB, n int) []byte {
+	ls := make([]LeapSecond, n)
+	for i := range ls {
+		ls[i] = LeapSecond{Tleap: uint64(78796800 + i*1000), Nleap: int32(i + 1)}
+	}
+	var b bytes.Buffer
+	require.NoError(tb, Write(&b, '2', ls, "UTC"))
+	return b.Bytes()
+}
+
+func Benchmark_ParseVx(b *testing.B) {
+	data := bigTZ(b, 10000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, _ = parseVx(bytes.NewReader(data))
+	}
+}
+
+func Benchmark_ParseReader(b *testing.B) {
+	data := bigTZ(b, 10000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, _ = ParseReader(bytes.NewReader(data))
+	}
 }
 
 func FuzzParse(f *testing.F) {
 	tzx2 := append(tz, tz...)
 	tzV2x2 := append(tzV2, tzV2...)
-	for _, seed := range [][]byte{{}, {0}, {9}, tz, tzV2, tzx2, tzV2x2} {
+	for _, seed := range [][]byte{{}, {0}, {9}, tz, tzV2, tzx2, tzV2x2, hugeLeapCnt()} {
 		f.Add(seed)
 	}
 	f.Fuzz(func(t *testing.T, b []byte) {
@@ -0,0 +1,173 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package leapsectz
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ntpEpochOffset is the number of seconds between NTP epoch (1900) and Unix epoch (1970)
+const ntpEpochOffset = 2208988800
+
+// timeNow returns current time: the update time of written leap-seconds.list, the moment Expired compares
+// expiration with and the one Cache.Latest looks up the latest leap second for. Tests replace it
+var timeNow = time.Now
+
+// leapSecondsList is a parsed IETF leap-seconds.list file
+type leapSecondsList struct {
+	updated time.Time
+	expires time.Time
+	leaps   []LeapSecond
+}
+
+// ntpToTime converts NTP seconds into time.Time
+func ntpToTime(sec uint64) time.Time {
+	return time.Unix(int64(sec)-ntpEpochOffset, 0)
+}
+
+// ParseLeapSecondsList returns the list of leap seconds from the IETF leap-seconds.list format
+// (like /usr/share/zoneinfo/leap-seconds.list)
+func ParseLeapSecondsList(r io.Reader) ([]LeapSecond, error) {
+	l, err := parseLeapSecondsList(r)
+	if err != nil {
+		return nil, err
+	}
+	return l.leaps, nil
+}
+
+func parseLeapSecondsList(r io.Reader) (*leapSecondsList, error) {
+	res := &leapSecondsList{}
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		if strings.HasPrefix(text, "#") {
+			// #$ is the last update time, #@ is the expiration time
+			if len(text) < 2 || (text[1] != '$' && text[1] != '@') {
+				continue
+			}
+			sec, err := strconv.ParseUint(strings.TrimSpace(text[2:]), 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("%w: line %d: %v", errBadData, line, err)
+			}
+			if text[1] == '$' {
+				res.updated = ntpToTime(sec)
+			} else {
+				res.expires = ntpToTime(sec)
+			}
+			continue
+		}
+
+		// NTP time, TAI-UTC, and optional comment
+		if i := strings.IndexByte(text, '#'); i >= 0 {
+			text = text[:i]
+		}
+		fields := strings.Fields(text)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%w: line %d", errBadData, line)
+		}
+		sec, err := strconv.ParseUint(fields[0], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: line %d: %v", errBadData, line, err)
+		}
+		dtai, err := strconv.ParseInt(fields[1], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("%w: line %d: %v", errBadData, line, err)
+		}
+
+		// the initial TAI-UTC difference is not a leap second
+		nleap := int32(dtai) - taiUTCInitial
+		if nleap == 0 && len(res.leaps) == 0 {
+			continue
+		}
+		// Tleap includes correction in effect before the event
+		var prev int32
+		if len(res.leaps) > 0 {
+			prev = res.leaps[len(res.leaps)-1].Nleap
+		}
+		res.leaps = append(res.leaps, LeapSecond{
+			Tleap: uint64(ntpToTime(sec).Unix() + int64(prev)),
+			Nleap: nleap,
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(res.leaps) == 0 {
+		return nil, errNoLeapSeconds
+	}
+	return res, nil
+}
+
+// timeToNTP converts time.Time into NTP seconds
+func timeToNTP(t time.Time) uint64 {
+	return uint64(t.Unix() + ntpEpochOffset)
+}
+
+// WriteLeapSecondsList dumps ls into w in the IETF leap-seconds.list format
+// with the current time as update time, expiry as expiration time and the hash line
+func WriteLeapSecondsList(w io.Writer, ls []LeapSecond, expiry time.Time) error {
+	updated := timeToNTP(timeNow())
+	expires := timeToNTP(expiry)
+
+	// hash covers update and expiration times and the data fields of every line
+	h := sha1.New()
+	fmt.Fprintf(h, "%d%d", updated, expires)
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "#$\t%d\n#@\t%d\n#\n", updated, expires)
+
+	// the initial TAI-UTC difference goes first
+	initial := time.Date(1972, time.January, 1, 0, 0, 0, 0, time.UTC)
+	lines := []struct {
+		t    time.Time
+		dtai int32
+	}{{initial, taiUTCInitial}}
+	for i, l := range ls {
+		lines = append(lines, struct {
+			t    time.Time
+			dtai int32
+		}{leapTime(ls, i).UTC(), taiUTCInitial + l.Nleap})
+	}
+	for _, l := range lines {
+		sec := timeToNTP(l.t)
+		fmt.Fprintf(h, "%d%d", sec, l.dtai)
+		fmt.Fprintf(bw, "%d\t%d\t# %d %s %d\n", sec, l.dtai, l.t.Day(), l.t.Month().String()[:3], l.t.Year())
+	}
+
+	sum := h.Sum(nil)
+	bw.WriteString("#h\t")
+	for i := 0; i < len(sum); i += 4 {
+		if i > 0 {
+			bw.WriteByte(' ')
+		}
+		fmt.Fprintf(bw, "%x", binary.BigEndian.Uint32(sum[i:]))
+	}
+	bw.WriteByte('\n')
+	return bw.Flush()
+}
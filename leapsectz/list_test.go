@@ -0,0 +1,169 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package leapsectz
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+var leapSecondsListData = `#
+#	In the following text, the symbol '#' introduces
+#	a comment, which continues from that symbol until
+#	the end of the line.
+#
+#$	3676924800
+#@	3707596800
+#
+2272060800	10	# 1 Jan 1972
+2287785600	11	# 1 Jul 1972
+2303683200	12	# 1 Jan 1973
+`
+
+func TestParseLeapSecondsList(t *testing.T) {
+	expected := []LeapSecond{
+		{78796800, 1},
+		{94694401, 2},
+	}
+	ls, err := ParseLeapSecondsList(strings.NewReader(leapSecondsListData))
+	require.NoError(t, err)
+	require.Equal(t, expected, ls)
+	require.Equal(t, time.Date(1972, time.July, 1, 0, 0, 0, 0, time.UTC), ls[0].Time().UTC())
+	require.Equal(t, time.Date(1973, time.January, 1, 0, 0, 0, 0, time.UTC), ls[1].Time().UTC())
+
+	l, err := parseLeapSecondsList(strings.NewReader(leapSecondsListData))
+	require.NoError(t, err)
+	require.Equal(t, time.Date(2016, time.July, 8, 0, 0, 0, 0, time.UTC), l.updated.UTC())
+	require.Equal(t, time.Date(2017, time.June, 28, 0, 0, 0, 0, time.UTC), l.expires.UTC())
+}
+
+func TestParseLeapSecondsListFail(t *testing.T) {
+	_, err := ParseLeapSecondsList(strings.NewReader("2272060800\t10\t# 1 Jan 1972\n"))
+	require.ErrorIs(t, err, errNoLeapSeconds)
+
+	_, err = ParseLeapSecondsList(strings.NewReader("2287785600\n"))
+	require.ErrorIs(t, err, errBadData)
+
+	_, err = ParseLeapSecondsList(strings.NewReader("2287785600\televen\n"))
+	require.ErrorIs(t, err, errBadData)
+
+	_, err = ParseLeapSecondsList(strings.NewReader("#@\tsoon\n2287785600\t11\n"))
+	require.ErrorIs(t, err, errBadData)
+}
+
+func TestWriteLeapSecondsList(t *testing.T) {
+	timeNow = func() time.Time { return time.Date(2016, time.July, 8, 0, 0, 0, 0, time.UTC) }
+	defer func() { timeNow = time.Now }()
+
+	ls := []LeapSecond{
+		{78796800, 1},
+		{94694401, 2},
+	}
+	expiry := time.Date(2017, time.June, 28, 0, 0, 0, 0, time.UTC)
+
+	var b bytes.Buffer
+	err := WriteLeapSecondsList(&b, ls, expiry)
+	require.NoError(t, err)
+
+	expected := "#$\t3676924800\n" +
+		"#@\t3707596800\n" +
+		"#\n" +
+		"2272060800\t10\t# 1 Jan 1972\n" +
+		"2287785600\t11\t# 1 Jul 1972\n" +
+		"2303683200\t12\t# 1 Jan 1973\n" +
+		"#h\tf00498b7 6edf34b1 87b24570 43751e8a d23b7d9e\n"
+	require.Equal(t, expected, b.String())
+
+	// round trip
+	l, err := parseLeapSecondsList(&b)
+	require.NoError(t, err)
+	require.Equal(t, ls, l.leaps)
+	require.Equal(t, expiry, l.expires.UTC())
+	require.Equal(t, timeNow(), l.updated.UTC())
+}
+
+// writeTemp writes b into a temporary file and returns its name
+func writeTemp(t *testing.T, b []byte) string {
+	f, err := os.CreateTemp(t.TempDir(), "leaptest-")
+	require.NoError(t, err)
+	_, err = f.Write(b)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	return f.Name()
+}
+
+func TestExpiredLeapSecondsList(t *testing.T) {
+	name := writeTemp(t, []byte(leapSecondsListData))
+
+	expired, expires, err := Expired(name)
+	require.NoError(t, err)
+	require.True(t, expired)
+	require.Equal(t, time.Date(2017, time.June, 28, 0, 0, 0, 0, time.UTC), expires.UTC())
+
+	timeNow = func() time.Time { return time.Date(2017, time.January, 1, 0, 0, 0, 0, time.UTC) }
+	defer func() { timeNow = time.Now }()
+	expired, _, err = Expired(name)
+	require.NoError(t, err)
+	require.False(t, expired)
+}
+
+func TestExpiredTZif(t *testing.T) {
+	// the last record repeats the correction and marks expiration on June 28, 2017
+	ls := []LeapSecond{
+		{78796800, 1},
+		{94694401, 2},
+		{1498608002, 2},
+	}
+	var b bytes.Buffer
+	err := Write(&b, '2', ls, "UTC")
+	require.NoError(t, err)
+
+	expired, expires, err := Expired(writeTemp(t, b.Bytes()))
+	require.NoError(t, err)
+	require.True(t, expired)
+	require.Equal(t, time.Date(2017, time.June, 28, 0, 0, 0, 0, time.UTC), expires.UTC())
+}
+
+func TestExpiredNoExpiry(t *testing.T) {
+	_, _, err := Expired(writeTemp(t, tzV2))
+	require.ErrorIs(t, err, errNoExpiry)
+
+	_, _, err = Expired(writeTemp(t, []byte("2287785600\t11\n")))
+	require.ErrorIs(t, err, errNoExpiry)
+
+	_, _, err = Expired("/does/not/exist")
+	require.Error(t, err)
+}
+
+func TestLeapSecondsListNegative(t *testing.T) {
+	timeNow = func() time.Time { return time.Date(1973, time.July, 8, 0, 0, 0, 0, time.UTC) }
+	defer func() { timeNow = time.Now }()
+
+	var b bytes.Buffer
+	err := WriteLeapSecondsList(&b, tzNegative, time.Date(1974, time.June, 28, 0, 0, 0, 0, time.UTC))
+	require.NoError(t, err)
+	require.Contains(t, b.String(), "2335219200\t11\t# 1 Jan 1974\n")
+
+	ls, err := ParseLeapSecondsList(&b)
+	require.NoError(t, err)
+	require.Equal(t, tzNegative, ls)
+}
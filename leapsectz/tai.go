@@ -0,0 +1,56 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package leapsectz
+
+import (
+	"time"
+)
+
+// taiOffset returns TAI-UTC difference for the number of leap seconds n
+func taiOffset(n int32) time.Duration {
+	return time.Duration(taiUTCInitial+n) * time.Second
+}
+
+// UTCToTAI converts UTC time t into TAI according to the chronologically ordered ls.
+// Before 1972 the initial 10 seconds difference is used.
+// A positive leap second (23:59:60) can't be represented by time.Time, so no UTC t maps into it
+func UTCToTAI(t time.Time, ls []LeapSecond) time.Time {
+	return t.Add(taiOffset(OffsetAt(ls, t)))
+}
+
+// TAIToUTC converts TAI time t into UTC according to the chronologically ordered ls.
+// When t falls into a positive leap second (23:59:60) the second before it is repeated
+// (as the Linux kernel does) and leap is true
+func TAIToUTC(t time.Time, ls []LeapSecond) (utc time.Time, leap bool) {
+	var prev int32
+	for i, l := range ls {
+		// TAI when the leap second starts, for negative ones it's the moment the offset changes
+		start := prev
+		if l.Nleap < prev {
+			start = l.Nleap
+		}
+		event := leapTime(ls, i)
+		if t.Before(event.Add(taiOffset(start))) {
+			break
+		}
+		if t.Before(event.Add(taiOffset(l.Nleap))) {
+			return t.Add(-taiOffset(l.Nleap)), true
+		}
+		prev = l.Nleap
+	}
+	return t.Add(-taiOffset(prev)), false
+}
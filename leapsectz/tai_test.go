@@ -0,0 +1,89 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package leapsectz
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUTCToTAI(t *testing.T) {
+	ls, err := parseVx(bytes.NewReader(tzV2))
+	require.NoError(t, err)
+
+	cases := []struct {
+		utc time.Time
+		tai time.Time
+	}{
+		{time.Date(1971, time.January, 1, 0, 0, 0, 0, time.UTC), time.Date(1971, time.January, 1, 0, 0, 10, 0, time.UTC)},
+		{time.Date(1972, time.June, 30, 23, 59, 59, 0, time.UTC), time.Date(1972, time.July, 1, 0, 0, 9, 0, time.UTC)},
+		{time.Date(1972, time.July, 1, 0, 0, 0, 0, time.UTC), time.Date(1972, time.July, 1, 0, 0, 11, 0, time.UTC)},
+		{time.Date(1972, time.December, 31, 23, 59, 59, 500000000, time.UTC), time.Date(1973, time.January, 1, 0, 0, 10, 500000000, time.UTC)},
+		{time.Date(1973, time.January, 1, 0, 0, 0, 0, time.UTC), time.Date(1973, time.January, 1, 0, 0, 12, 0, time.UTC)},
+		{time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC), time.Date(2020, time.January, 1, 0, 0, 12, 0, time.UTC)},
+	}
+	for _, c := range cases {
+		require.Equal(t, c.tai, UTCToTAI(c.utc, ls).UTC(), c.utc)
+		utc, leap := TAIToUTC(c.tai, ls)
+		require.False(t, leap, c.tai)
+		require.Equal(t, c.utc, utc.UTC(), c.tai)
+	}
+}
+
+func TestTAIToUTCLeapSecond(t *testing.T) {
+	ls, err := parseVx(bytes.NewReader(tzV2))
+	require.NoError(t, err)
+
+	// 1972-06-30 23:59:60 and 1972-12-31 23:59:60.25
+	utc, leap := TAIToUTC(time.Date(1972, time.July, 1, 0, 0, 10, 0, time.UTC), ls)
+	require.True(t, leap)
+	require.Equal(t, time.Date(1972, time.June, 30, 23, 59, 59, 0, time.UTC), utc.UTC())
+
+	utc, leap = TAIToUTC(time.Date(1973, time.January, 1, 0, 0, 11, 250000000, time.UTC), ls)
+	require.True(t, leap)
+	require.Equal(t, time.Date(1972, time.December, 31, 23, 59, 59, 250000000, time.UTC), utc.UTC())
+}
+
+func TestTAIToUTCNegativeLeapSecond(t *testing.T) {
+	// 1973-12-31 23:59:59 is skipped
+	before := time.Date(1973, time.December, 31, 23, 59, 58, 0, time.UTC)
+	after := time.Date(1974, time.January, 1, 0, 0, 0, 0, time.UTC)
+	require.Equal(t, before.Add(12*time.Second), UTCToTAI(before, tzNegative))
+	require.Equal(t, before.Add(13*time.Second), UTCToTAI(after, tzNegative))
+
+	utc, leap := TAIToUTC(before.Add(12*time.Second), tzNegative)
+	require.False(t, leap)
+	require.Equal(t, before, utc)
+	utc, leap = TAIToUTC(before.Add(13*time.Second), tzNegative)
+	require.False(t, leap)
+	require.Equal(t, after, utc)
+}
+
+func TestTAIRoundTrip(t *testing.T) {
+	ls, err := parseVx(bytes.NewReader(tzV2))
+	require.NoError(t, err)
+
+	start := time.Date(1972, time.June, 30, 23, 59, 50, 0, time.UTC)
+	for utc := start; utc.Before(start.Add(20 * time.Second)); utc = utc.Add(100 * time.Millisecond) {
+		got, leap := TAIToUTC(UTCToTAI(utc, ls), ls)
+		require.False(t, leap)
+		require.Equal(t, utc, got.UTC())
+	}
+}
@@ -17,6 +17,7 @@ limitations under the License.
 package control
 
 import (
+	"encoding/binary"
 	"strings"
 
 	"github.com/pkg/errors"
@@ -80,6 +81,48 @@ type NTPControlMsg struct {
 	Data []uint8
 }
 
+// headSize is the size of NTPControlMsgHead on the wire
+const headSize = 12
+
+// MarshalBinary converts NTPControlMsg to []bytes.
+// Count is set from the length of Data and Data is padded to 32 bit boundary
+func (n *NTPControlMsg) MarshalBinary() ([]byte, error) {
+	if len(n.Data) > 0xffff {
+		return nil, errors.Errorf("data is too long: %d bytes", len(n.Data))
+	}
+	padding := (4 - len(n.Data)%4) % 4
+	b := make([]byte, headSize+len(n.Data)+padding)
+	b[0] = n.VnMode
+	b[1] = n.REMOp
+	binary.BigEndian.PutUint16(b[2:], n.Sequence)
+	binary.BigEndian.PutUint16(b[4:], n.Status)
+	binary.BigEndian.PutUint16(b[6:], n.AssociationID)
+	binary.BigEndian.PutUint16(b[8:], n.Offset)
+	binary.BigEndian.PutUint16(b[10:], uint16(len(n.Data)))
+	copy(b[headSize:], n.Data)
+	return b, nil
+}
+
+// UnmarshalBinary fills NTPControlMsg from []bytes. Padding after Data is ignored
+func (n *NTPControlMsg) UnmarshalBinary(b []byte) error {
+	if len(b) < headSize {
+		return errors.Errorf("not enough data to decode NTPControlMsg: %d bytes", len(b))
+	}
+	n.VnMode = b[0]
+	n.REMOp = b[1]
+	n.Sequence = binary.BigEndian.Uint16(b[2:])
+	n.Status = binary.BigEndian.Uint16(b[4:])
+	n.AssociationID = binary.BigEndian.Uint16(b[6:])
+	n.Offset = binary.BigEndian.Uint16(b[8:])
+	n.Count = binary.BigEndian.Uint16(b[10:])
+	if len(b) < headSize+int(n.Count) {
+		return errors.Errorf("not enough data to decode NTPControlMsg: %d bytes, expected %d", len(b), headSize+int(n.Count))
+	}
+	n.Data = make([]uint8, n.Count)
+	copy(n.Data, b[headSize:headSize+int(n.Count)])
+	return nil
+}
+
 // LeapDesc stores human-readable descriptions of LI (leap indicator) field
 var LeapDesc = [4]string{"none", "add_sec", "del_sec", "alarm"}
 
@@ -225,3 +225,64 @@ func TestNTPControlMsg_GetAssociations(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, want, got)
 }
+
+// readVariablesResponse is a hand-built read variables response, laid out like the one ntpd sends to "ntpq -c rv".
+// It is not a packet capture
+var readVariablesResponse = append([]byte{
+	0x16, 0x82, 0x00, 0x01, // version 2, mode 6, response, read variables, sequence 1
+	0x06, 0x18, 0x00, 0x00, // system status, association 0
+	0x00, 0x00, 0x00, 0x63, // offset 0, count 99
+}, []byte(`version="ntpd 4.2.6p5@1.2349-o", leap=00, stratum=2, precision=-24, rootdelay=0.194, rootdisp=14.29`)...)
+
+func TestNTPControlMsgUnmarshalBinary(t *testing.T) {
+	// data padded to 32 bit boundary
+	b := append(append([]byte{}, readVariablesResponse...), 0x00)
+	msg := &NTPControlMsg{}
+	err := msg.UnmarshalBinary(b)
+	require.NoError(t, err)
+	require.Equal(t, 2, msg.GetVersion())
+	require.Equal(t, Mode, msg.GetMode())
+	require.True(t, msg.IsResponse())
+	require.False(t, msg.HasMore())
+	require.Equal(t, uint8(OpReadVariables), msg.GetOperation())
+	require.Equal(t, uint16(1), msg.Sequence)
+	require.Equal(t, uint16(99), msg.Count)
+
+	info, err := msg.GetAssociationInfo()
+	require.NoError(t, err)
+	require.Equal(t, "ntpd 4.2.6p5@1.2349-o", info["version"])
+	require.Equal(t, "2", info["stratum"])
+	require.Equal(t, "0.194", info["rootdelay"])
+	require.Equal(t, "14.29", info["rootdisp"])
+}
+
+func TestNTPControlMsgUnmarshalBinaryShort(t *testing.T) {
+	msg := &NTPControlMsg{}
+	require.Error(t, msg.UnmarshalBinary(readVariablesResponse[:10]))
+	require.Error(t, msg.UnmarshalBinary(readVariablesResponse[:50]))
+}
+
+func TestNTPControlMsgMarshalBinary(t *testing.T) {
+	msg := &NTPControlMsg{}
+	err := msg.UnmarshalBinary(readVariablesResponse)
+	require.NoError(t, err)
+
+	b, err := msg.MarshalBinary()
+	require.NoError(t, err)
+	require.Equal(t, 0, len(b)%4)
+	require.Equal(t, readVariablesResponse, b[:len(readVariablesResponse)])
+	require.Equal(t, []byte{0x00}, b[len(readVariablesResponse):])
+}
+
+func TestNTPControlMsgMarshalBinaryRequest(t *testing.T) {
+	msg := &NTPControlMsg{
+		NTPControlMsgHead: NTPControlMsgHead{
+			VnMode:   MakeVnMode(2, Mode),
+			REMOp:    OpReadStatus,
+			Sequence: 1,
+		},
+	}
+	b, err := msg.MarshalBinary()
+	require.NoError(t, err)
+	require.Equal(t, []byte{0x16, 0x01, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}, b)
+}
@@ -0,0 +1,61 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package protocol
+
+import (
+	"fmt"
+	"net"
+	"time"
+)
+
+// ListenBroadcast joins multicast group (like 224.0.1.1:123) on the default interface
+// and returns connection to read broadcast server packets from
+func ListenBroadcast(group string) (*net.UDPConn, error) {
+	addr, err := net.ResolveUDPAddr("udp", group)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve %s: %w", group, err)
+	}
+	if !addr.IP.IsMulticast() {
+		return nil, fmt.Errorf("%s is not a multicast address", addr.IP)
+	}
+	conn, err := net.ListenMulticastUDP("udp", nil, addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to join %s: %w", group, err)
+	}
+	return conn, nil
+}
+
+// NewBroadcastPacket returns NTPv4 broadcast server packet with transmit timestamp set to txTime
+func NewBroadcastPacket(txTime time.Time, stratum uint8, refID uint32) *Packet {
+	p := &Packet{Stratum: stratum, Poll: defaultPoll, ReferenceID: refID}
+	p.SetVersion(clientVersion)
+	p.SetMode(modeBroadcast)
+	p.SetTransmitTime(txTime)
+	return p
+}
+
+// IsBroadcast returns true if packet was sent by a broadcast server (mode 5)
+func (p *Packet) IsBroadcast() bool {
+	return p.Mode() == modeBroadcast
+}
+
+// BroadcastOffset returns clock offset in nanoseconds estimated from broadcast packet received at rxTime.
+// Broadcast is one way, so there is no round trip to measure: delay is the one-way delay from the server,
+// usually calibrated beforehand with a regular client exchange as half of its RTT
+func (p *Packet) BroadcastOffset(rxTime time.Time, delay time.Duration) int64 {
+	return p.TxTimestamp().ToTime().Add(delay).Sub(rxTime).Nanoseconds()
+}
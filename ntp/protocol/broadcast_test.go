@@ -0,0 +1,69 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package protocol
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestListenBroadcast(t *testing.T) {
+	conn, err := ListenBroadcast("224.0.1.1:0")
+	if err != nil {
+		t.Skipf("multicast is not available: %v", err)
+	}
+	defer conn.Close()
+	port := conn.LocalAddr().(*net.UDPAddr).Port
+
+	sender, err := net.DialUDP("udp", nil, &net.UDPAddr{IP: net.ParseIP("224.0.1.1"), Port: port})
+	require.NoError(t, err)
+	defer sender.Close()
+
+	serverTime := time.Now().Add(time.Hour)
+	require.NoError(t, WritePacket(sender, NewBroadcastPacket(serverTime, 1, ntpResponse.ReferenceID), nil))
+
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+	p, _, err := ReadNTPPacket(conn)
+	if err != nil {
+		t.Skipf("multicast is not routed: %v", err)
+	}
+	rxTime := time.Now()
+	require.True(t, p.IsBroadcast())
+	require.False(t, p.IsRequest())
+	require.Equal(t, "FB  ", p.RefIDString())
+	require.InDelta(t, int64(time.Hour), p.BroadcastOffset(rxTime, 0), float64(100*time.Millisecond))
+}
+
+func TestListenBroadcastNotMulticast(t *testing.T) {
+	_, err := ListenBroadcast("127.0.0.1:0")
+	require.Error(t, err)
+}
+
+func TestBroadcastOffset(t *testing.T) {
+	serverTime := time.Date(2021, time.June, 1, 12, 0, 0, 0, time.UTC)
+	p := NewBroadcastPacket(serverTime, 1, 0)
+	require.True(t, p.IsBroadcast())
+	require.Equal(t, uint8(4), p.Version())
+
+	// packet took 5ms to arrive and local clock is 1s ahead
+	rxTime := serverTime.Add(time.Second + 5*time.Millisecond)
+	require.Equal(t, int64(-time.Second), p.BroadcastOffset(rxTime, 5*time.Millisecond))
+	require.False(t, ntpResponse.IsBroadcast())
+}
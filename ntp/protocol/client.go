@@ -0,0 +1,405 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package protocol
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// ErrTimeout is returned when server didn't reply in time
+var ErrTimeout = errors.New("timeout waiting for reply from server")
+
+// ErrKissOfDeath is returned when server replied with a Kiss-o'-Death packet
+var ErrKissOfDeath = errors.New("kiss-o'-death response")
+
+// ErrUnsynchronized is returned when server clock is not synchronized (leap indicator is 3)
+var ErrUnsynchronized = errors.New("server clock is not synchronized")
+
+// ErrOriginMismatch is returned when response origin timestamp doesn't match the request
+var ErrOriginMismatch = errors.New("response origin timestamp doesn't match the request")
+
+// ErrNoUsableResponse is returned when none of the servers replied with a usable response
+var ErrNoUsableResponse = errors.New("no usable response from servers")
+
+// ErrUnsupported is returned when a QueryOptions socket option is not supported on this platform
+var ErrUnsupported = errors.New("not supported on this platform")
+
+const (
+	clientVersion = 4
+	// defaultPoll is a default poll interval (2^6 = 64 seconds)
+	defaultPoll = 6
+	// sntpTimeout is how long SNTP waits for the reply
+	sntpTimeout = 5 * time.Second
+)
+
+// Now returns current time used for the client timestamps (T1 and T4).
+// Tests can replace it to get deterministic results
+var Now = time.Now
+
+// NewClientPacket returns NTPv4 client request with transmit timestamp set to now
+func NewClientPacket() *Packet {
+	p := &Packet{Poll: defaultPoll}
+	p.SetLeapIndicator(liNoWarning)
+	p.SetVersion(clientVersion)
+	p.SetMode(modeClient)
+	p.SetTransmitTime(Now())
+	return p
+}
+
+// Response is a server reply along with everything computed from the exchange
+type Response struct {
+	Packet *Packet
+	// Offset is the clock offset in nanoseconds
+	Offset int64
+	// RTT is the round trip delay in nanoseconds
+	RTT int64
+	// T1 the client timestamp on the request packet
+	OriginTime time.Time
+	// T2 the server timestamp upon arrival
+	ServerReceiveTime time.Time
+	// T3 the server timestamp on departure of the reply packet
+	ServerTransmitTime time.Time
+	// T4 the client timestamp upon arrival
+	ClientReceiveTime time.Time
+}
+
+// NewResponse builds Response from the server reply, the time request was sent (T1)
+// and the time reply was received (T4)
+func NewResponse(p *Packet, originTime, clientReceiveTime time.Time) *Response {
+	serverReceiveTime := p.ReceiveTime()
+	serverTransmitTime := p.TransmitTime()
+
+	return &Response{
+		Packet:             p,
+		Offset:             Offset(originTime, serverReceiveTime, serverTransmitTime, clientReceiveTime),
+		RTT:                RoundTripDelay(originTime, serverReceiveTime, serverTransmitTime, clientReceiveTime),
+		OriginTime:         originTime,
+		ServerReceiveTime:  serverReceiveTime,
+		ServerTransmitTime: serverTransmitTime,
+		ClientReceiveTime:  clientReceiveTime,
+	}
+}
+
+// Query sends a single NTP client request to address (host:port)
+// and returns the server response along with the computed clock offset in nanoseconds
+func Query(address string, timeout time.Duration) (*Packet, int64, error) {
+	r, err := QueryResponse(address, timeout)
+	if err != nil {
+		return nil, 0, err
+	}
+	return r.Packet, r.Offset, nil
+}
+
+// QueryOptions control how the client socket is set up
+type QueryOptions struct {
+	// LocalAddr is the source address to send the request from
+	LocalAddr *net.UDPAddr
+	// Interface is the network interface to send the request from. Linux binds the socket to it
+	// with SO_BINDTODEVICE, elsewhere an address of the interface is used as LocalAddr unless that is set
+	Interface string
+	// DSCP is the Differentiated Services Code Point to mark requests with (Linux only, ErrUnsupported elsewhere)
+	DSCP uint8
+	// RandomTxTimestamp puts a random nonce instead of the real time into the transmit timestamp of the request.
+	// Server must echo it back in the origin timestamp, which makes spoofing harder
+	RandomTxTimestamp bool
+	// Stats, if not nil, counts packets sent and received
+	Stats *Stats
+	// ReplayDetector, if not nil, rejects responses seen before with ErrReplay
+	ReplayDetector *ReplayDetector
+}
+
+// control is applied to the client socket before it's connected
+func (o QueryOptions) control(network, _ string, c syscall.RawConn) error {
+	if o.Interface == "" && o.DSCP == 0 {
+		return nil
+	}
+	var sockErr error
+	err := c.Control(func(fd uintptr) {
+		if o.Interface != "" && canBindToDevice {
+			if sockErr = bindToDevice(int(fd), o.Interface); sockErr != nil {
+				return
+			}
+		}
+		if o.DSCP != 0 {
+			sockErr = setDSCP(int(fd), network, o.DSCP)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return sockErr
+}
+
+// interfaceLocalAddr returns an address of the network interface iface of the same IP family as address (host:port),
+// to send requests from where sockets can't be bound to an interface
+func interfaceLocalAddr(iface, address string) (*net.UDPAddr, error) {
+	ifi, err := net.InterfaceByName(iface)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find interface %q: %w", iface, err)
+	}
+	remote, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve %s: %w", address, err)
+	}
+	addrs, err := ifi.Addrs()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get addresses of interface %q: %w", iface, err)
+	}
+	for _, a := range addrs {
+		ipnet, ok := a.(*net.IPNet)
+		if !ok || (ipnet.IP.To4() == nil) != (remote.IP.To4() == nil) {
+			continue
+		}
+		local := &net.UDPAddr{IP: ipnet.IP}
+		if ipnet.IP.IsLinkLocalUnicast() {
+			local.Zone = ifi.Name
+		}
+		return local, nil
+	}
+	return nil, fmt.Errorf("interface %q has no address to reach %s", iface, address)
+}
+
+// QueryResponse sends a single NTP client request to address (host:port)
+// and returns the server response along with offset, round trip delay and all timestamps
+func QueryResponse(address string, timeout time.Duration) (*Response, error) {
+	return QueryWithOptions(address, timeout, QueryOptions{})
+}
+
+// QueryWithOptions is QueryResponse with client socket set up according to opts
+func QueryWithOptions(address string, timeout time.Duration, opts QueryOptions) (*Response, error) {
+	d := net.Dialer{Timeout: timeout, Control: opts.control}
+	if opts.LocalAddr != nil {
+		d.LocalAddr = opts.LocalAddr
+	} else if opts.Interface != "" && !canBindToDevice {
+		local, err := interfaceLocalAddr(opts.Interface, address)
+		if err != nil {
+			return nil, err
+		}
+		d.LocalAddr = local
+	}
+	conn, err := d.Dial("udp", address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", address, err)
+	}
+	defer conn.Close()
+
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return nil, err
+	}
+
+	r, err := exchange(conn, opts)
+	if err != nil {
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			opts.Stats.incTimeouts()
+			return nil, fmt.Errorf("%w %s for %v", ErrTimeout, address, timeout)
+		}
+		return nil, err
+	}
+	return r, nil
+}
+
+// QueryContext sends a single NTP client request to address (host:port)
+// and returns the server response. It honors ctx cancellation and deadline
+func QueryContext(ctx context.Context, address string) (*Response, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "udp", address)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("failed to connect to %s: %w", address, err)
+	}
+	defer conn.Close()
+
+	// closing conn unblocks the read once ctx is cancelled or its deadline passes
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	r, err := exchange(conn, QueryOptions{})
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+	return r, nil
+}
+
+// SNTP performs a single exchange with the server at address (host:port) and returns the clock offset.
+// No filtering or selection is done, but Kiss-o'-Death and unsynchronized responses are rejected
+func SNTP(address string) (time.Duration, error) {
+	r, err := QueryResponse(address, sntpTimeout)
+	if err != nil {
+		return 0, err
+	}
+	if r.Packet.IsKissOfDeath() {
+		return 0, fmt.Errorf("%w %q from %s", ErrKissOfDeath, r.Packet.KissCode(), address)
+	}
+	if r.Packet.LeapIndicator() == liAlarmCondition {
+		return 0, fmt.Errorf("%w: %s", ErrUnsynchronized, address)
+	}
+	return time.Duration(r.Offset), nil
+}
+
+// QueryPool queries all addrs (host:port) concurrently and returns the response with the lowest root distance.
+// Failed queries and Kiss-o'-Death responses are discarded
+func QueryPool(addrs []string, timeout time.Duration) (*Response, error) {
+	responses := make([]*Response, len(addrs))
+	errs := make([]error, len(addrs))
+	var wg sync.WaitGroup
+	for i, addr := range addrs {
+		wg.Add(1)
+		go func(i int, addr string) {
+			defer wg.Done()
+			responses[i], errs[i] = QueryResponse(addr, timeout)
+		}(i, addr)
+	}
+	wg.Wait()
+
+	var best *Response
+	var bestDistance time.Duration
+	var lastErr error
+	for i, r := range responses {
+		if errs[i] != nil {
+			lastErr = errs[i]
+			continue
+		}
+		if r.Packet.IsKissOfDeath() {
+			lastErr = fmt.Errorf("%w %q from %s", ErrKissOfDeath, r.Packet.KissCode(), addrs[i])
+			continue
+		}
+		distance := r.Packet.RootDistance(r.RTT)
+		if best == nil || distance < bestDistance {
+			best, bestDistance = r, distance
+		}
+	}
+	if best == nil {
+		if lastErr != nil {
+			return nil, fmt.Errorf("%w: %v", ErrNoUsableResponse, lastErr)
+		}
+		return nil, ErrNoUsableResponse
+	}
+	return best, nil
+}
+
+// exchange sends client request over connected conn and waits for the reply until conn deadline.
+// Replies with origin timestamp not matching the request are discarded.
+// With opts.RandomTxTimestamp transmit timestamp of the request is a random nonce and the origin time is tracked locally
+func exchange(conn net.Conn, opts QueryOptions) (*Response, error) {
+	request := NewClientPacket()
+	originTime := request.TxTimestamp().ToTime()
+	if opts.RandomTxTimestamp {
+		nonce, err := newNonce()
+		if err != nil {
+			return nil, err
+		}
+		request.SetTxTimestamp(nonce)
+	}
+	if err := sendRequest(conn, request, opts.Stats); err != nil {
+		return nil, err
+	}
+	mismatched := false
+	for {
+		response, clientReceiveTime, err := readResponse(conn, opts.Stats)
+		if err != nil {
+			if mismatched {
+				return nil, fmt.Errorf("%w, no matching one: %v", ErrOriginMismatch, err)
+			}
+			return nil, err
+		}
+		// replies not matching our request may be spoofed, skip them and wait for the real one
+		if !response.MatchesOriginTimestamp(request.TxTimestamp()) {
+			mismatched = true
+			continue
+		}
+		if response.IsKissOfDeath() {
+			opts.Stats.incKissOfDeath()
+		}
+		if opts.ReplayDetector != nil {
+			if err := opts.ReplayDetector.Check(response); err != nil {
+				return nil, err
+			}
+		}
+		return NewResponse(response, originTime, clientReceiveTime), nil
+	}
+}
+
+// newNonce returns a random NTPTimestamp
+func newNonce() (NTPTimestamp, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return NTPTimestamp{}, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	return NTPTimestamp{Seconds: binary.BigEndian.Uint32(b), Fraction: binary.BigEndian.Uint32(b[4:])}, nil
+}
+
+// roundTrip sends request over connected conn and returns the reply along with the time it was received.
+// stats may be nil
+func roundTrip(conn net.Conn, request *Packet, stats *Stats) (*Packet, time.Time, error) {
+	if err := sendRequest(conn, request, stats); err != nil {
+		return nil, time.Time{}, err
+	}
+	return readResponse(conn, stats)
+}
+
+// sendRequest writes request into connected conn. stats may be nil
+func sendRequest(conn net.Conn, request *Packet, stats *Stats) error {
+	b, err := request.Bytes()
+	if err != nil {
+		return err
+	}
+	if _, err := conn.Write(b); err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	stats.incSent()
+	return nil
+}
+
+// readResponse reads a single reply from connected conn and returns it along with the time it was received.
+// stats may be nil
+func readResponse(conn net.Conn, stats *Stats) (*Packet, time.Time, error) {
+	buf := make([]byte, PacketSizeBytes)
+	n, err := conn.Read(buf)
+	clientReceiveTime := Now()
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("failed to read response: %w", err)
+	}
+	stats.incReceived()
+
+	response, err := BytesToPacket(buf[:n])
+	if err != nil {
+		stats.incDecodeErrors()
+		return nil, time.Time{}, err
+	}
+	return response, clientReceiveTime, nil
+}
@@ -0,0 +1,38 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package protocol
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// canBindToDevice tells if sockets can be bound to an interface by its name
+const canBindToDevice = true
+
+// bindToDevice binds socket to the network interface with SO_BINDTODEVICE
+func bindToDevice(fd int, iface string) error {
+	return unix.SetsockoptString(fd, unix.SOL_SOCKET, unix.SO_BINDTODEVICE, iface)
+}
+
+// setDSCP sets IP_TOS (or IPV6_TCLASS for IPv6) on the socket. DSCP is the upper 6 bits of the field
+func setDSCP(fd int, network string, dscp uint8) error {
+	tos := int(dscp) << 2
+	if network == "udp6" {
+		return unix.SetsockoptInt(fd, unix.IPPROTO_IPV6, unix.IPV6_TCLASS, tos)
+	}
+	return unix.SetsockoptInt(fd, unix.IPPROTO_IP, unix.IP_TOS, tos)
+}
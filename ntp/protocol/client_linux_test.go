@@ -0,0 +1,75 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package protocol
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/facebook/time/timestamp"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+func TestQueryWithOptionsBadInterface(t *testing.T) {
+	conn := startFakeServer(t, 0)
+	defer conn.Close()
+
+	_, err := QueryWithOptions(conn.LocalAddr().String(), time.Second, QueryOptions{Interface: "nosuchiface0"})
+	require.Error(t, err)
+}
+
+func TestSetDSCP(t *testing.T) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 0})
+	require.NoError(t, err)
+	defer conn.Close()
+	connFd, err := timestamp.ConnFd(conn)
+	require.NoError(t, err)
+
+	// EF (Expedited Forwarding)
+	err = setDSCP(connFd, "udp4", 46)
+	require.NoError(t, err)
+	tos, err := unix.GetsockoptInt(connFd, unix.IPPROTO_IP, unix.IP_TOS)
+	require.NoError(t, err)
+	require.Equal(t, 46<<2, tos)
+}
+
+func TestSetDSCPIPv6(t *testing.T) {
+	conn, err := net.ListenUDP("udp6", &net.UDPAddr{IP: net.IPv6loopback, Port: 0})
+	if err != nil {
+		t.Skipf("IPv6 is not available: %v", err)
+	}
+	defer conn.Close()
+	connFd, err := timestamp.ConnFd(conn)
+	require.NoError(t, err)
+
+	err = setDSCP(connFd, "udp6", 46)
+	require.NoError(t, err)
+	tclass, err := unix.GetsockoptInt(connFd, unix.IPPROTO_IPV6, unix.IPV6_TCLASS)
+	require.NoError(t, err)
+	require.Equal(t, 46<<2, tclass)
+}
+
+func TestQueryWithOptionsDSCP(t *testing.T) {
+	conn := startFakeServer(t, 0)
+	defer conn.Close()
+
+	r, err := QueryWithOptions(conn.LocalAddr().String(), time.Second, QueryOptions{DSCP: 46})
+	require.NoError(t, err)
+	require.Equal(t, uint8(1), r.Packet.Stratum)
+}
@@ -0,0 +1,38 @@
+//go:build !linux
+// +build !linux
+
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package protocol
+
+import (
+	"fmt"
+)
+
+// canBindToDevice tells if sockets can be bound to an interface by its name.
+// Without it QueryOptions.Interface falls back to sending from an address of the interface
+const canBindToDevice = false
+
+// bindToDevice is not supported outside of Linux, interfaceLocalAddr is used instead
+func bindToDevice(_ int, _ string) error {
+	return fmt.Errorf("binding to interface: %w", ErrUnsupported)
+}
+
+// setDSCP is not supported outside of Linux
+func setDSCP(_ int, _ string, _ uint8) error {
+	return fmt.Errorf("setting DSCP: %w", ErrUnsupported)
+}
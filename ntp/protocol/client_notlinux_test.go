@@ -0,0 +1,59 @@
+//go:build !linux
+// +build !linux
+
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package protocol
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSocketOptionsUnsupported(t *testing.T) {
+	require.ErrorIs(t, bindToDevice(0, "eth0"), ErrUnsupported)
+	require.ErrorIs(t, setDSCP(0, "udp4", 46), ErrUnsupported)
+}
+
+func TestQueryWithOptionsInterface(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 0})
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// reply and remember where the request came from
+	from := make(chan net.Addr, 1)
+	go func() {
+		request, addr, err := ReadNTPPacket(conn)
+		if err != nil {
+			return
+		}
+		from <- addr
+		_ = WritePacket(conn, NewServerResponse(request, time.Now(), time.Now(), 1, 0), addr)
+	}()
+
+	// no SO_BINDTODEVICE, request is sent from the address of the interface
+	r, err := QueryWithOptions(conn.LocalAddr().String(), time.Second, QueryOptions{Interface: loopbackInterface(t)})
+	require.NoError(t, err)
+	require.Equal(t, uint8(1), r.Packet.Stratum)
+	require.True(t, (<-from).(*net.UDPAddr).IP.IsLoopback())
+
+	_, err = QueryWithOptions(conn.LocalAddr().String(), time.Second, QueryOptions{Interface: "nosuchiface0"})
+	require.Error(t, err)
+}
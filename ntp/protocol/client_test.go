@@ -0,0 +1,397 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package protocol
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// startFakeServer replies to every request with a stratum 1 response
+// whose clock is shifted by serverOffset
+func startFakeServer(t *testing.T, serverOffset time.Duration) *net.UDPConn {
+	return startSlowFakeServer(t, serverOffset, 0, 1)
+}
+
+// startSlowFakeServer replies to every request after delay with a response of given stratum
+// whose clock is shifted by serverOffset. Delay happens before the request is timestamped,
+// so it looks like network delay and adds to the round trip time
+func startSlowFakeServer(t *testing.T, serverOffset, delay time.Duration, stratum uint8) *net.UDPConn {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 0})
+	require.NoError(t, err)
+
+	go func() {
+		for {
+			request, addr, err := ReadNTPPacket(conn)
+			if err != nil {
+				return
+			}
+			time.Sleep(delay)
+			received := time.Now().Add(serverOffset)
+			response := NewServerResponse(request, received, time.Now().Add(serverOffset), stratum, 0)
+			b, err := response.Bytes()
+			if err != nil {
+				return
+			}
+			_, _ = conn.WriteTo(b, addr)
+		}
+	}()
+
+	return conn
+}
+
+func TestNewClientPacket(t *testing.T) {
+	before := time.Now()
+	p := NewClientPacket()
+	require.True(t, p.ValidSettingsFormat())
+	require.Equal(t, uint8(0x23), p.Settings)
+	require.Equal(t, uint8(0), p.LeapIndicator())
+	require.Equal(t, uint8(4), p.Version())
+	require.Equal(t, uint8(3), p.Mode())
+	require.Equal(t, int8(6), p.Poll)
+	require.WithinDuration(t, before, Unix(p.TxTimeSec, p.TxTimeFrac), time.Second)
+}
+
+func TestNewClientPacketFrozenClock(t *testing.T) {
+	frozen := time.Date(2021, time.June, 1, 12, 0, 0, 0, time.UTC)
+	defer func(orig func() time.Time) { Now = orig }(Now)
+	Now = func() time.Time { return frozen }
+
+	p := NewClientPacket()
+	sec, frac := Time(frozen)
+	require.Equal(t, sec, p.TxTimeSec)
+	require.Equal(t, frac, p.TxTimeFrac)
+}
+
+func TestQueryFrozenClock(t *testing.T) {
+	frozen := time.Date(2021, time.June, 1, 12, 0, 0, 0, time.UTC)
+	defer func(orig func() time.Time) { Now = orig }(Now)
+	Now = func() time.Time { return frozen }
+
+	// server is exactly an hour ahead and replies instantly
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 0})
+	require.NoError(t, err)
+	defer conn.Close()
+	go func() {
+		request, addr, err := ReadNTPPacket(conn)
+		if err != nil {
+			return
+		}
+		serverTime := frozen.Add(time.Hour)
+		_ = WritePacket(conn, NewServerResponse(request, serverTime, serverTime, 1, 0), addr)
+	}()
+
+	r, err := QueryResponse(conn.LocalAddr().String(), time.Second)
+	require.NoError(t, err)
+	require.Equal(t, int64(time.Hour), r.Offset)
+	require.Equal(t, int64(0), r.RTT)
+	require.Equal(t, frozen, r.OriginTime.UTC())
+	require.Equal(t, frozen, r.ClientReceiveTime)
+}
+
+func TestQuery(t *testing.T) {
+	serverOffset := time.Hour
+	conn := startFakeServer(t, serverOffset)
+	defer conn.Close()
+
+	response, offset, err := Query(conn.LocalAddr().String(), time.Second)
+	require.NoError(t, err)
+	require.Equal(t, uint8(1), response.Stratum)
+	require.Equal(t, uint8(4), response.Mode())
+	require.InDelta(t, serverOffset.Nanoseconds(), offset, float64(100*time.Millisecond))
+}
+
+func TestQueryTimeout(t *testing.T) {
+	// listener which never replies
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 0})
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, _, err = Query(conn.LocalAddr().String(), 50*time.Millisecond)
+	require.ErrorIs(t, err, ErrTimeout)
+}
+
+func TestQueryResponse(t *testing.T) {
+	conn := startFakeServer(t, 0)
+	defer conn.Close()
+
+	r, err := QueryResponse(conn.LocalAddr().String(), time.Second)
+	require.NoError(t, err)
+	require.Equal(t, uint8(1), r.Packet.Stratum)
+	require.Greater(t, r.RTT, int64(0))
+	require.False(t, r.ClientReceiveTime.Before(r.OriginTime))
+}
+
+func TestNewResponse(t *testing.T) {
+	originTime := time.Unix(usec, 0)
+	// Network delay client -> server 10ms
+	serverReceiveTime := originTime.Add(forwardDelay)
+	// OS delay server 10us
+	serverTransmitTime := serverReceiveTime.Add(10 * time.Microsecond)
+	// Network delay client -> server 20ms
+	clientReceiveTime := serverTransmitTime.Add(returnDelay)
+
+	p := &Packet{}
+	p.RxTimeSec, p.RxTimeFrac = Time(serverReceiveTime)
+	p.TxTimeSec, p.TxTimeFrac = Time(serverTransmitTime)
+
+	r := NewResponse(p, originTime, clientReceiveTime)
+	require.Equal(t, p, r.Packet)
+	require.Equal(t, originTime, r.OriginTime)
+	require.Equal(t, clientReceiveTime, r.ClientReceiveTime)
+	require.Equal(t, offset, r.Offset)
+	require.Equal(t, roundTripDelay, r.RTT)
+}
+
+func TestQueryContext(t *testing.T) {
+	conn := startFakeServer(t, 0)
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	r, err := QueryContext(ctx, conn.LocalAddr().String())
+	require.NoError(t, err)
+	require.Equal(t, uint8(1), r.Packet.Stratum)
+}
+
+func TestQueryContextCancel(t *testing.T) {
+	// listener which never replies
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 0})
+	require.NoError(t, err)
+	defer conn.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	_, err = QueryContext(ctx, conn.LocalAddr().String())
+	require.ErrorIs(t, err, context.Canceled)
+}
+
+func TestQueryContextDeadline(t *testing.T) {
+	// listener which never replies
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 0})
+	require.NoError(t, err)
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = QueryContext(ctx, conn.LocalAddr().String())
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestQueryWithOptionsLocalAddr(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 0})
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// reply and remember where the request came from
+	from := make(chan net.Addr, 1)
+	go func() {
+		request, addr, err := ReadNTPPacket(conn)
+		if err != nil {
+			return
+		}
+		from <- addr
+		b, err := NewServerResponse(request, time.Now(), time.Now(), 1, 0).Bytes()
+		if err != nil {
+			return
+		}
+		_, _ = conn.WriteTo(b, addr)
+	}()
+
+	local := &net.UDPAddr{IP: net.ParseIP("127.0.0.2"), Port: 0}
+	r, err := QueryWithOptions(conn.LocalAddr().String(), time.Second, QueryOptions{LocalAddr: local})
+	require.NoError(t, err)
+	require.Equal(t, uint8(1), r.Packet.Stratum)
+	require.Equal(t, "127.0.0.2", (<-from).(*net.UDPAddr).IP.String())
+}
+
+// loopbackInterface returns the name of the loopback interface
+func loopbackInterface(t *testing.T) string {
+	ifaces, err := net.Interfaces()
+	require.NoError(t, err)
+	for _, ifi := range ifaces {
+		if ifi.Flags&net.FlagLoopback != 0 {
+			return ifi.Name
+		}
+	}
+	t.Skip("no loopback interface")
+	return ""
+}
+
+func TestInterfaceLocalAddr(t *testing.T) {
+	lo := loopbackInterface(t)
+	local, err := interfaceLocalAddr(lo, "127.0.0.1:123")
+	require.NoError(t, err)
+	require.True(t, local.IP.IsLoopback())
+	require.NotNil(t, local.IP.To4())
+	require.Equal(t, 0, local.Port)
+
+	_, err = interfaceLocalAddr("nosuchiface0", "127.0.0.1:123")
+	require.Error(t, err)
+	_, err = interfaceLocalAddr(lo, "no port")
+	require.Error(t, err)
+}
+
+func TestQueryPool(t *testing.T) {
+	fast := startSlowFakeServer(t, 0, 0, 1)
+	defer fast.Close()
+	slow := startSlowFakeServer(t, time.Hour, 50*time.Millisecond, 1)
+	defer slow.Close()
+	kod := startSlowFakeServer(t, time.Hour, 0, 0)
+	defer kod.Close()
+
+	r, err := QueryPool([]string{slow.LocalAddr().String(), kod.LocalAddr().String(), fast.LocalAddr().String()}, time.Second)
+	require.NoError(t, err)
+	require.Equal(t, uint8(1), r.Packet.Stratum)
+	require.Less(t, r.RTT, (50 * time.Millisecond).Nanoseconds())
+	require.InDelta(t, 0, r.Offset, float64(10*time.Millisecond))
+}
+
+func TestQueryPoolNoUsableResponse(t *testing.T) {
+	kod := startSlowFakeServer(t, 0, 0, 0)
+	defer kod.Close()
+
+	_, err := QueryPool([]string{kod.LocalAddr().String()}, time.Second)
+	require.ErrorIs(t, err, ErrNoUsableResponse)
+
+	_, err = QueryPool(nil, time.Second)
+	require.ErrorIs(t, err, ErrNoUsableResponse)
+}
+
+func TestSNTP(t *testing.T) {
+	serverOffset := -time.Hour
+	conn := startFakeServer(t, serverOffset)
+	defer conn.Close()
+
+	offset, err := SNTP(conn.LocalAddr().String())
+	require.NoError(t, err)
+	require.InDelta(t, serverOffset.Nanoseconds(), offset.Nanoseconds(), float64(100*time.Millisecond))
+}
+
+func TestSNTPKissOfDeath(t *testing.T) {
+	conn := startSlowFakeServer(t, 0, 0, 0)
+	defer conn.Close()
+
+	_, err := SNTP(conn.LocalAddr().String())
+	require.ErrorIs(t, err, ErrKissOfDeath)
+}
+
+func TestSNTPUnsynchronized(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 0})
+	require.NoError(t, err)
+	defer conn.Close()
+	go func() {
+		_ = Serve(conn, func(req *Packet) *Packet {
+			now := time.Now()
+			response := NewServerResponse(req, now, now, 1, 0)
+			response.SetLeapIndicator(liAlarmCondition)
+			return response
+		})
+	}()
+
+	_, err = SNTP(conn.LocalAddr().String())
+	require.ErrorIs(t, err, ErrUnsynchronized)
+}
+
+func TestQueryOriginMismatch(t *testing.T) {
+	// spoofed response doesn't know what client sent
+	requests := make(chan *Packet, 1)
+	conn := startEchoServer(t, 1, requests)
+	defer conn.Close()
+
+	_, err := QueryResponse(conn.LocalAddr().String(), time.Second)
+	require.ErrorIs(t, err, ErrOriginMismatch)
+}
+
+// startEchoServer runs Serve with a handler replying with stratum 1 response
+// and origin timestamp shifted by originShift fractions
+func startEchoServer(t *testing.T, originShift uint32, requests chan<- *Packet) *net.UDPConn {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 0})
+	require.NoError(t, err)
+	go func() {
+		_ = Serve(conn, func(req *Packet) *Packet {
+			requests <- req
+			now := time.Now()
+			response := NewServerResponse(req, now, now, 1, 0)
+			response.OrigTimeFrac += originShift
+			return response
+		})
+	}()
+	return conn
+}
+
+func TestQueryWithOptionsRandomTxTimestamp(t *testing.T) {
+	requests := make(chan *Packet, 1)
+	conn := startEchoServer(t, 0, requests)
+	defer conn.Close()
+
+	before := time.Now()
+	r, err := QueryWithOptions(conn.LocalAddr().String(), time.Second, QueryOptions{RandomTxTimestamp: true})
+	require.NoError(t, err)
+	request := <-requests
+	// nonce is echoed back and the origin time is still real
+	require.True(t, r.Packet.MatchesOriginTimestamp(request.TxTimestamp()))
+	require.WithinDuration(t, before, r.OriginTime, time.Second)
+	require.InDelta(t, 0, r.Offset, float64(100*time.Millisecond))
+}
+
+func TestQueryWithOptionsRandomTxTimestampMismatch(t *testing.T) {
+	requests := make(chan *Packet, 1)
+	conn := startEchoServer(t, 1, requests)
+	defer conn.Close()
+
+	_, err := QueryWithOptions(conn.LocalAddr().String(), time.Second, QueryOptions{RandomTxTimestamp: true})
+	require.ErrorIs(t, err, ErrOriginMismatch)
+}
+
+func TestQuerySkipsSpoofedResponse(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 0})
+	require.NoError(t, err)
+	defer conn.Close()
+	go func() {
+		for {
+			request, addr, err := ReadNTPPacket(conn)
+			if err != nil {
+				return
+			}
+			// spoofed Kiss-o'-Death arrives first
+			spoofed := NewKissOfDeathResponse(request, KissCodeRate)
+			spoofed.OrigTimeFrac++
+			_ = WritePacket(conn, spoofed, addr)
+			now := time.Now()
+			_ = WritePacket(conn, NewServerResponse(request, now, now, 1, 0), addr)
+		}
+	}()
+
+	stats := &Stats{}
+	r, err := QueryWithOptions(conn.LocalAddr().String(), time.Second, QueryOptions{Stats: stats})
+	require.NoError(t, err)
+	require.False(t, r.Packet.IsKissOfDeath())
+	require.Equal(t, Stats{PacketsSent: 1, PacketsReceived: 2}, stats.Snapshot())
+}
+
+func TestNewNonce(t *testing.T) {
+	a, err := newNonce()
+	require.NoError(t, err)
+	b, err := newNonce()
+	require.NoError(t, err)
+	require.NotEqual(t, a, b)
+}
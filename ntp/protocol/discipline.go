@@ -0,0 +1,53 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package protocol
+
+import (
+	"time"
+)
+
+// Discipline is a proportional-integral clock discipline loop.
+// It turns offset samples into a phase correction and a frequency correction
+// to apply to the local clock (for example with adjtimex). It's not safe for concurrent use
+type Discipline struct {
+	kp float64
+	ki float64
+
+	freq     float64
+	last     time.Time
+	hasFirst bool
+}
+
+// NewDiscipline returns Discipline with proportional gain kp and integral gain ki
+func NewDiscipline(kp, ki float64) *Discipline {
+	return &Discipline{kp: kp, ki: ki}
+}
+
+// Step feeds the offset sample into the loop and returns the phase correction to add to the local clock
+// and the total frequency correction in parts per million. Frequency is first updated on the second sample,
+// as it needs time passed since the previous one
+func (d *Discipline) Step(sample *Response) (time.Duration, float64) {
+	offset := float64(sample.Offset)
+	if d.hasFirst {
+		if interval := sample.ClientReceiveTime.Sub(d.last); interval > 0 {
+			d.freq += d.ki * offset / float64(interval) * 1e6
+		}
+	}
+	d.last = sample.ClientReceiveTime
+	d.hasFirst = true
+	return time.Duration(d.kp * offset), d.freq
+}
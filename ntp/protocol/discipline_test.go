@@ -0,0 +1,58 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package protocol
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDisciplineConverges(t *testing.T) {
+	// local clock runs 10 ppm slow and starts 5ms behind the server
+	const drift = 10.0
+	offset := 5 * time.Millisecond
+	now := time.Date(2021, time.June, 1, 12, 0, 0, 0, time.UTC)
+	d := NewDiscipline(0.5, 0.1)
+
+	var freq float64
+	var adjust time.Duration
+	for i := 0; i < 200; i++ {
+		adjust, freq = d.Step(&Response{Offset: int64(offset), ClientReceiveTime: now})
+		// apply corrections and let a second pass
+		offset -= adjust
+		offset += time.Duration((drift - freq) * float64(time.Second) / 1e6)
+		now = now.Add(time.Second)
+	}
+	require.InDelta(t, drift, freq, 0.01)
+	require.Less(t, math.Abs(float64(offset)), float64(time.Microsecond))
+}
+
+func TestDisciplineFirstStep(t *testing.T) {
+	d := NewDiscipline(0.5, 0.1)
+	now := time.Date(2021, time.June, 1, 12, 0, 0, 0, time.UTC)
+	adjust, freq := d.Step(&Response{Offset: int64(time.Millisecond), ClientReceiveTime: now})
+	require.Equal(t, 500*time.Microsecond, adjust)
+	require.Equal(t, 0.0, freq)
+
+	// 1ms over 10s is 100 ppm, integral gain takes a tenth of it
+	adjust, freq = d.Step(&Response{Offset: int64(time.Millisecond), ClientReceiveTime: now.Add(10 * time.Second)})
+	require.Equal(t, 500*time.Microsecond, adjust)
+	require.InDelta(t, 10.0, freq, 1e-9)
+}
@@ -0,0 +1,160 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package protocol
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// ErrInvalidExtension is returned when extension field can't be decoded or encoded
+var ErrInvalidExtension = errors.New("invalid extension field")
+
+const (
+	// extensionHeaderSizeBytes is the size of Field Type and Length
+	extensionHeaderSizeBytes = 4
+	// extensionMinSizeBytes is the minimum size of extension field as per RFC 7822
+	extensionMinSizeBytes = 16
+	// lastExtensionMinSizeBytes is the minimum size of the last extension field when there is no MAC,
+	// so it can't be mistaken for one (RFC 7822 section 7.5)
+	lastExtensionMinSizeBytes = 28
+)
+
+// ExtensionField is an NTPv4 extension field as per RFC 7822
+/*
+   0                   1                   2                   3
+   0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1
+  +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+  |          Field Type           |            Length             |
+  +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+  .                                                               .
+  .                            Value                              .
+  .                                                               .
+  +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+  |                       Padding (as needed)                     |
+  +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+*/
+type ExtensionField struct {
+	Type uint16
+	// Length is the length of the whole field including header and padding.
+	// It's calculated from Value on marshalling. The last field of a Packet without MAC
+	// is padded to 28 bytes on the wire regardless of Length
+	Length uint16
+	// Value of the field. Decoded Value includes padding as there is no way to tell them apart
+	Value []byte
+}
+
+// size returns the length of the extension field on the wire
+func (ef *ExtensionField) size() int {
+	return ef.sizeAtLeast(extensionMinSizeBytes)
+}
+
+// sizeAtLeast returns the length of the extension field on the wire padded to at least minSize
+func (ef *ExtensionField) sizeAtLeast(minSize int) int {
+	l := extensionHeaderSizeBytes + len(ef.Value)
+	if l < minSize {
+		l = minSize
+	}
+	// padded to 4 bytes boundary
+	return (l + 3) &^ 3
+}
+
+// marshalTo writes extension field of l bytes into b which must be at least l long.
+// It fails if the field is too long for the 16-bit Length
+func (ef *ExtensionField) marshalTo(b []byte, l int) (int, error) {
+	if l > math.MaxUint16 {
+		return 0, fmt.Errorf("%w: type %#04x is %d bytes long, at most %d allowed", ErrInvalidExtension, ef.Type, l, math.MaxUint16)
+	}
+	binary.BigEndian.PutUint16(b[0:], ef.Type)
+	binary.BigEndian.PutUint16(b[2:], uint16(l))
+	n := copy(b[extensionHeaderSizeBytes:], ef.Value)
+	// zero the padding
+	for i := extensionHeaderSizeBytes + n; i < l; i++ {
+		b[i] = 0
+	}
+	return l, nil
+}
+
+// AppendExtension adds extension field to the Packet
+func (p *Packet) AppendExtension(ef ExtensionField) {
+	ef.Length = uint16(ef.size())
+	p.extensions = append(p.extensions, ef)
+}
+
+// Extensions returns extension fields of the Packet
+func (p *Packet) Extensions() []ExtensionField {
+	return p.extensions
+}
+
+// extensionSize returns the wire length of i-th extension field.
+// Without MAC the last one is padded to lastExtensionMinSizeBytes
+func (p *Packet) extensionSize(i int, withMAC bool) int {
+	if !withMAC && i == len(p.extensions)-1 {
+		return p.extensions[i].sizeAtLeast(lastExtensionMinSizeBytes)
+	}
+	return p.extensions[i].size()
+}
+
+// extensionsSize returns the length of all extension fields on the wire
+func (p *Packet) extensionsSize(withMAC bool) int {
+	size := 0
+	for i := range p.extensions {
+		size += p.extensionSize(i, withMAC)
+	}
+	return size
+}
+
+// isMAC tells if b following extension fields is a MAC rather than the last extension field.
+// Senders which don't pad the last field to 28 bytes make a 20 or 24 bytes field ambiguous:
+// it's taken as extension field if its Length covers b exactly and Field Type is set,
+// as key IDs below 65536 have zeroes in its place
+func isMAC(b []byte) bool {
+	switch len(b) {
+	case cryptoNAKSizeBytes, keyIDSizeBytes + md5.Size, keyIDSizeBytes + sha1.Size:
+	default:
+		return false
+	}
+	if len(b) < extensionMinSizeBytes {
+		return true
+	}
+	return binary.BigEndian.Uint16(b[0:]) == 0 || int(binary.BigEndian.Uint16(b[2:])) != len(b)
+}
+
+// parseExtensions decodes extension fields from the data following the header.
+// It returns the remaining bytes which don't belong to extension fields
+func parseExtensions(b []byte) ([]ExtensionField, []byte, error) {
+	var extensions []ExtensionField
+	for len(b) >= extensionMinSizeBytes && !isMAC(b) {
+		length := int(binary.BigEndian.Uint16(b[2:]))
+		if length < extensionMinSizeBytes || length%4 != 0 || length > len(b) {
+			return nil, nil, fmt.Errorf("%w: length %d with %d bytes left", ErrInvalidExtension, length, len(b))
+		}
+		ef := ExtensionField{
+			Type:   binary.BigEndian.Uint16(b[0:]),
+			Length: uint16(length),
+			Value:  make([]byte, length-extensionHeaderSizeBytes),
+		}
+		copy(ef.Value, b[extensionHeaderSizeBytes:length])
+		extensions = append(extensions, ef)
+		b = b[length:]
+	}
+	return extensions, b, nil
+}
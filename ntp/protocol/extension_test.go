@@ -0,0 +1,225 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package protocol
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestExtensionsRoundTrip(t *testing.T) {
+	// 16 and 32 bytes long on the wire, no padding required
+	ef1 := ExtensionField{Type: 0x0104, Value: []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12}}
+	ef2 := ExtensionField{Type: 0x0204, Value: make([]byte, 28)}
+	for i := range ef2.Value {
+		ef2.Value[i] = byte(i)
+	}
+
+	p := *ntpRequest
+	p.AppendExtension(ef1)
+	p.AppendExtension(ef2)
+	require.Equal(t, 2, len(p.Extensions()))
+
+	b, err := p.Bytes()
+	require.NoError(t, err)
+	require.Equal(t, PacketSizeBytes+16+32, len(b))
+	require.Equal(t, ntpRequestBytes, b[:PacketSizeBytes])
+	require.Equal(t, []byte{0x01, 0x04, 0x00, 0x10}, b[PacketSizeBytes:PacketSizeBytes+4])
+	require.Equal(t, []byte{0x02, 0x04, 0x00, 0x20}, b[PacketSizeBytes+16:PacketSizeBytes+20])
+
+	decoded, err := BytesToPacket(b)
+	require.NoError(t, err)
+	require.Equal(t, &p, decoded)
+	require.Equal(t, ef1.Value, decoded.Extensions()[0].Value)
+	require.Equal(t, uint16(16), decoded.Extensions()[0].Length)
+	require.Equal(t, ef2.Value, decoded.Extensions()[1].Value)
+	require.Equal(t, uint16(32), decoded.Extensions()[1].Length)
+}
+
+func TestExtensionsPadding(t *testing.T) {
+	p := &Packet{}
+	// shorter than minimum
+	p.AppendExtension(ExtensionField{Type: 1, Value: []byte{0xff}})
+	// not aligned to 4 bytes
+	p.AppendExtension(ExtensionField{Type: 2, Value: make([]byte, 23)})
+	require.Equal(t, uint16(16), p.Extensions()[0].Length)
+	require.Equal(t, uint16(28), p.Extensions()[1].Length)
+
+	b, err := p.Bytes()
+	require.NoError(t, err)
+	require.Equal(t, PacketSizeBytes+16+28, len(b))
+	require.Equal(t, []byte{0x00, 0x01, 0x00, 0x10, 0xff, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}, b[PacketSizeBytes:PacketSizeBytes+16])
+
+	decoded, err := BytesToPacket(b)
+	require.NoError(t, err)
+	// padding is returned as a part of the value
+	require.Equal(t, []byte{0xff, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}, decoded.Extensions()[0].Value)
+	require.Equal(t, 24, len(decoded.Extensions()[1].Value))
+}
+
+func TestExtensionsLastPadded(t *testing.T) {
+	for _, size := range []int{16, 20, 24} {
+		ef := ExtensionField{Type: 0x0104, Value: make([]byte, size-extensionHeaderSizeBytes)}
+		for i := range ef.Value {
+			ef.Value[i] = byte(i + 1)
+		}
+		p := NewClientPacket()
+		p.AppendExtension(ef)
+		require.Equal(t, uint16(size), p.Extensions()[0].Length)
+
+		b, err := p.Bytes()
+		require.NoError(t, err)
+		// without MAC the last field is padded so it can't be taken for one
+		require.Equal(t, PacketSizeBytes+lastExtensionMinSizeBytes, len(b), "size %d", size)
+		require.Equal(t, []byte{0x01, 0x04, 0x00, 0x1c}, b[PacketSizeBytes:PacketSizeBytes+4])
+
+		decoded, err := BytesToPacket(b)
+		require.NoError(t, err)
+		require.Len(t, decoded.Extensions(), 1, "size %d", size)
+		require.Nil(t, decoded.mac)
+		got := decoded.Extensions()[0]
+		require.Equal(t, ef.Type, got.Type)
+		require.Equal(t, uint16(lastExtensionMinSizeBytes), got.Length)
+		require.Equal(t, ef.Value, got.Value[:len(ef.Value)])
+		require.Equal(t, make([]byte, lastExtensionMinSizeBytes-size), got.Value[len(ef.Value):])
+	}
+}
+
+func TestExtensionsNotLastUnpadded(t *testing.T) {
+	p := NewClientPacket()
+	p.AppendExtension(ExtensionField{Type: 1, Value: make([]byte, 12)})
+	p.AppendExtension(ExtensionField{Type: 2, Value: make([]byte, 12)})
+	b, err := p.Bytes()
+	require.NoError(t, err)
+	require.Equal(t, PacketSizeBytes+16+28, len(b))
+
+	// with MAC the last field keeps its size
+	require.NoError(t, p.AppendMAC(1, []byte("secret"), MACAlgoSHA1))
+	b, err = p.Bytes()
+	require.NoError(t, err)
+	require.Equal(t, PacketSizeBytes+16+16+24, len(b))
+
+	decoded, err := BytesToPacket(b)
+	require.NoError(t, err)
+	require.Len(t, decoded.Extensions(), 2)
+	ok, err := decoded.VerifyMAC([]byte("secret"))
+	require.NoError(t, err)
+	require.True(t, ok)
+}
+
+func TestExtensionsUnpaddedLast(t *testing.T) {
+	// senders not following RFC 7822 section 7.5 may end the packet with a short extension field
+	for _, size := range []int{16, 20, 24} {
+		ef := make([]byte, size)
+		ef[1] = 0x04
+		ef[3] = byte(size)
+		b := append(append([]byte{}, ntpResponseBytes...), ef...)
+
+		decoded, err := BytesToPacket(b)
+		require.NoError(t, err)
+		require.Len(t, decoded.Extensions(), 1, "size %d", size)
+		require.Equal(t, uint16(4), decoded.Extensions()[0].Type)
+		require.Equal(t, uint16(size), decoded.Extensions()[0].Length)
+		require.Nil(t, decoded.mac)
+	}
+
+	// MAC with key ID 20 looks like a 20 bytes field of type 0
+	mac := make([]byte, 20)
+	mac[3] = 20
+	b := append(append([]byte{}, ntpResponseBytes...), mac...)
+	decoded, err := BytesToPacket(b)
+	require.NoError(t, err)
+	require.Empty(t, decoded.Extensions())
+	require.Equal(t, mac, decoded.mac)
+}
+
+func TestExtensionsMalformed(t *testing.T) {
+	b := append(append([]byte{}, ntpResponseBytes...), 0x00, 0x01, 0x00, 0x0f)
+	b = append(b, make([]byte, 28)...)
+	_, err := BytesToPacket(b)
+	require.ErrorIs(t, err, ErrInvalidExtension)
+
+	// length is over the end of the packet
+	b = append(append([]byte{}, ntpResponseBytes...), 0x00, 0x01, 0x01, 0x00)
+	b = append(b, make([]byte, 28)...)
+	_, err = BytesToPacket(b)
+	require.ErrorIs(t, err, ErrInvalidExtension)
+}
+
+func TestExtensionsDirtyPacket(t *testing.T) {
+	p := &Packet{}
+	p.AppendExtension(ExtensionField{Type: 1, Value: []byte{1}})
+	err := p.UnmarshalBinary(ntpResponseBytes)
+	require.NoError(t, err)
+	require.Nil(t, p.Extensions())
+	require.Equal(t, ntpResponse, p)
+}
+
+func TestMarshalToShortBufferExtensions(t *testing.T) {
+	p := &Packet{}
+	p.AppendExtension(ExtensionField{Type: 1, Value: []byte{1}})
+	b := make([]byte, PacketSizeBytes)
+	_, err := p.MarshalTo(b)
+	require.Error(t, err)
+}
+
+func TestMarshalExtensionTooLong(t *testing.T) {
+	p := NewClientPacket()
+	// fits exactly
+	p.AppendExtension(ExtensionField{Type: 1, Value: make([]byte, math.MaxUint16-extensionHeaderSizeBytes-3)})
+	b, err := p.Bytes()
+	require.NoError(t, err)
+	require.Equal(t, []byte{0xff, 0xfc}, b[PacketSizeBytes+2:PacketSizeBytes+4])
+
+	// padded length doesn't fit into Length
+	p = NewClientPacket()
+	p.AppendExtension(ExtensionField{Type: 1, Value: make([]byte, math.MaxUint16-extensionHeaderSizeBytes-2)})
+	_, err = p.Bytes()
+	require.ErrorIs(t, err, ErrInvalidExtension)
+	_, err = p.MarshalTo(make([]byte, p.size()))
+	require.ErrorIs(t, err, ErrInvalidExtension)
+}
+
+func TestCloneExtensions(t *testing.T) {
+	p := NewClientPacket()
+	p.AppendExtension(ExtensionField{Type: 0x0104, Value: []byte{1, 2, 3, 4}})
+	p.mac = []byte{0, 0, 0, 1, 0xaa, 0xbb}
+
+	c := p.Clone()
+	require.Equal(t, p, c)
+
+	c.Extensions()[0].Value[0] = 42
+	c.Extensions()[0].Type = 0x0204
+	c.AppendExtension(ExtensionField{Type: 0x0304, Value: []byte{5, 6, 7, 8}})
+	c.mac[4] = 0
+	c.TxTimeSec++
+
+	require.Len(t, p.Extensions(), 1)
+	require.Equal(t, uint16(0x0104), p.Extensions()[0].Type)
+	require.Equal(t, []byte{1, 2, 3, 4}, p.Extensions()[0].Value)
+	require.Equal(t, []byte{0, 0, 0, 1, 0xaa, 0xbb}, p.mac)
+	require.NotEqual(t, p.TxTimeSec, c.TxTimeSec)
+}
+
+func TestCloneNoExtensions(t *testing.T) {
+	p := NewClientPacket()
+	c := p.Clone()
+	require.Equal(t, p, c)
+	require.NotSame(t, p, c)
+}
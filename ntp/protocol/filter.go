@@ -0,0 +1,233 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package protocol
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// outlierSigmas is how many standard deviations away from the mean an offset must be to be discarded
+const outlierSigmas = 2
+
+// BestSample implements NTP clock filter heuristic:
+// the sample with the lowest round trip delay has the most trustworthy offset.
+// Returns nil if there are no samples
+func BestSample(responses []*Response) *Response {
+	var best *Response
+	for _, r := range responses {
+		if r == nil {
+			continue
+		}
+		if best == nil || r.RTT < best.RTT {
+			best = r
+		}
+	}
+	return best
+}
+
+// Jitter returns root-mean-square of successive offset differences in nanoseconds.
+// Returns 0 if there are less than 2 samples
+func Jitter(offsets []int64) float64 {
+	if len(offsets) < 2 {
+		return 0
+	}
+	var sum float64
+	for i := 1; i < len(offsets); i++ {
+		diff := float64(offsets[i] - offsets[i-1])
+		sum += diff * diff
+	}
+	return math.Sqrt(sum / float64(len(offsets)-1))
+}
+
+// MeasureOffset queries the server at address (host:port) samples times, interval apart,
+// and returns the median clock offset in nanoseconds. Offsets further than two standard deviations
+// from the mean are discarded. Failed queries are skipped
+func MeasureOffset(address string, samples int, interval time.Duration) (int64, error) {
+	if samples < 1 {
+		return 0, fmt.Errorf("invalid number of samples: %d", samples)
+	}
+	offsets := make([]int64, 0, samples)
+	var lastErr error
+	for i := 0; i < samples; i++ {
+		if i > 0 {
+			time.Sleep(interval)
+		}
+		_, offset, err := Query(address, sntpTimeout)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		offsets = append(offsets, offset)
+	}
+	if len(offsets) == 0 {
+		return 0, fmt.Errorf("%w: %v", ErrNoUsableResponse, lastErr)
+	}
+	return median(withoutOutliers(offsets)), nil
+}
+
+// withoutOutliers returns offsets no further than outlierSigmas standard deviations from the mean
+func withoutOutliers(offsets []int64) []int64 {
+	var mean float64
+	for _, o := range offsets {
+		mean += float64(o)
+	}
+	mean /= float64(len(offsets))
+	var variance float64
+	for _, o := range offsets {
+		d := float64(o) - mean
+		variance += d * d
+	}
+	limit := outlierSigmas * math.Sqrt(variance/float64(len(offsets)))
+
+	res := make([]int64, 0, len(offsets))
+	for _, o := range offsets {
+		if math.Abs(float64(o)-mean) <= limit {
+			res = append(res, o)
+		}
+	}
+	return res
+}
+
+// median returns the median of offsets, which must not be empty
+func median(offsets []int64) int64 {
+	sorted := append([]int64(nil), offsets...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	n := len(sorted)
+	if n%2 == 1 {
+		return sorted[n/2]
+	}
+	return (sorted[n/2-1] + sorted[n/2]) / 2
+}
+
+// endpoint is an edge or a midpoint of a correctness interval used by SelectTruechimers
+type endpoint struct {
+	value int64
+	// typ is +1 for the lower edge, -1 for the upper edge and 0 for the midpoint
+	typ int
+}
+
+// SelectTruechimers implements NTP intersection algorithm (RFC 5905, section 11.2.1).
+// Each response defines correctness interval [offset - root distance, offset + root distance].
+// It finds the smallest intersection agreed on by the majority of the servers and returns the servers
+// whose intervals overlap with it (truechimers). Returns nil if there is no majority
+func SelectTruechimers(responses []*Response) []*Response {
+	candidates := make([]*Response, 0, len(responses))
+	for _, r := range responses {
+		if r != nil {
+			candidates = append(candidates, r)
+		}
+	}
+	n := len(candidates)
+	if n == 0 {
+		return nil
+	}
+
+	lows := make([]int64, n)
+	highs := make([]int64, n)
+	endpoints := make([]endpoint, 0, 3*n)
+	for i, r := range candidates {
+		distance := int64(r.Packet.RootDistance(r.RTT))
+		lows[i], highs[i] = r.Offset-distance, r.Offset+distance
+		endpoints = append(endpoints,
+			endpoint{value: lows[i], typ: 1},
+			endpoint{value: r.Offset, typ: 0},
+			endpoint{value: highs[i], typ: -1},
+		)
+	}
+	// lower edges go first, so touching intervals overlap
+	sort.Slice(endpoints, func(i, j int) bool {
+		if endpoints[i].value != endpoints[j].value {
+			return endpoints[i].value < endpoints[j].value
+		}
+		return endpoints[i].typ > endpoints[j].typ
+	})
+
+	// allow f falsetickers, increasing until majority agrees
+	for f := 0; 2*f < n; f++ {
+		var low, high int64
+		found := 0
+		chime := 0
+		for _, e := range endpoints {
+			chime += e.typ
+			if chime >= n-f {
+				low = e.value
+				break
+			}
+			if e.typ == 0 {
+				found++
+			}
+		}
+		chime = 0
+		for i := len(endpoints) - 1; i >= 0; i-- {
+			e := endpoints[i]
+			chime -= e.typ
+			if chime >= n-f {
+				high = e.value
+				break
+			}
+			if e.typ == 0 {
+				found++
+			}
+		}
+		if found > f || low > high {
+			continue
+		}
+
+		truechimers := make([]*Response, 0, n)
+		for i, r := range candidates {
+			if lows[i] <= high && highs[i] >= low {
+				truechimers = append(truechimers, r)
+			}
+		}
+		return truechimers
+	}
+	return nil
+}
+
+// CombineOffsets returns the average offset of the truechimers picked by SelectTruechimers
+// weighted by the inverse of their root distance (NTP combine algorithm), in nanoseconds.
+// Returns 0 if there are no truechimers
+func CombineOffsets(responses []*Response) int64 {
+	var sum, weights float64
+	for _, r := range SelectTruechimers(responses) {
+		distance := r.Packet.RootDistance(r.RTT)
+		if distance <= 0 {
+			distance = time.Nanosecond
+		}
+		weight := 1 / float64(distance)
+		sum += weight * float64(r.Offset)
+		weights += weight
+	}
+	if weights == 0 {
+		return 0
+	}
+	return int64(math.Round(sum / weights))
+}
+
+// FrequencyError returns how fast the offset changes between samples s1 and s2 in parts per million:
+// (offset2 - offset1) / (t2 - t1), where t is when the response was received.
+// Positive value means local clock runs slower than the server. Returns 0 if samples were taken at the same time
+func FrequencyError(s1, s2 *Response) float64 {
+	interval := s2.ClientReceiveTime.Sub(s1.ClientReceiveTime)
+	if interval == 0 {
+		return 0
+	}
+	return float64(s2.Offset-s1.Offset) / float64(interval) * 1e6
+}
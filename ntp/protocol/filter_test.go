@@ -0,0 +1,177 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package protocol
+
+import (
+	"math/rand"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBestSample(t *testing.T) {
+	responses := []*Response{
+		{Offset: 100, RTT: 5000},
+		{Offset: 10, RTT: 9000},
+		{Offset: 300, RTT: 1000},
+		nil,
+		{Offset: 200, RTT: 3000},
+	}
+	best := BestSample(responses)
+	require.Equal(t, responses[2], best)
+}
+
+func TestBestSampleEmpty(t *testing.T) {
+	require.Nil(t, BestSample(nil))
+	require.Nil(t, BestSample([]*Response{nil}))
+}
+
+func TestJitter(t *testing.T) {
+	require.Equal(t, float64(2), Jitter([]int64{0, 2, 0, 2}))
+	// sqrt((3^2 + 4^2) / 2)
+	require.InDelta(t, 3.5355, Jitter([]int64{1, 4, 0}), 0.0001)
+	// constant offset means no jitter
+	require.Equal(t, float64(0), Jitter([]int64{offset, offset, offset}))
+}
+
+func TestJitterNotEnoughSamples(t *testing.T) {
+	require.Equal(t, float64(0), Jitter(nil))
+	require.Equal(t, float64(0), Jitter([]int64{offset}))
+}
+
+// startJitterServer replies with a clock shifted by serverOffset plus random jitter up to maxJitter.
+// Every outlierEvery-th reply is shifted by an extra second
+func startJitterServer(t *testing.T, serverOffset, maxJitter time.Duration, outlierEvery int) *net.UDPConn {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 0})
+	require.NoError(t, err)
+
+	go func() {
+		for n := 1; ; n++ {
+			request, addr, err := ReadNTPPacket(conn)
+			if err != nil {
+				return
+			}
+			offset := serverOffset + time.Duration(rand.Int63n(int64(2*maxJitter))) - maxJitter
+			if n%outlierEvery == 0 {
+				offset += time.Second
+			}
+			now := time.Now().Add(offset)
+			response := NewServerResponse(request, now, now, 1, 0)
+			b, err := response.Bytes()
+			if err != nil {
+				return
+			}
+			_, _ = conn.WriteTo(b, addr)
+		}
+	}()
+
+	return conn
+}
+
+func TestMeasureOffset(t *testing.T) {
+	serverOffset := time.Hour
+	conn := startJitterServer(t, serverOffset, time.Millisecond, 5)
+	defer conn.Close()
+
+	offset, err := MeasureOffset(conn.LocalAddr().String(), 20, time.Millisecond)
+	require.NoError(t, err)
+	require.InDelta(t, int64(serverOffset), offset, float64(5*time.Millisecond))
+}
+
+func TestMeasureOffsetErrors(t *testing.T) {
+	_, err := MeasureOffset("127.0.0.1:123", 0, time.Millisecond)
+	require.Error(t, err)
+}
+
+func TestWithoutOutliers(t *testing.T) {
+	offsets := []int64{10, 12, 11, 9, 10, 11, 10, 9, 12, 1000}
+	require.Equal(t, []int64{10, 12, 11, 9, 10, 11, 10, 9, 12}, withoutOutliers(offsets))
+	require.Equal(t, []int64{5, 5}, withoutOutliers([]int64{5, 5}))
+}
+
+func TestMedian(t *testing.T) {
+	require.Equal(t, int64(3), median([]int64{5, 1, 3}))
+	require.Equal(t, int64(4), median([]int64{5, 1, 3, 7}))
+	require.Equal(t, int64(42), median([]int64{42}))
+}
+
+// responseWithDistance returns Response with given offset and root distance of about rootDispersion
+func responseWithDistance(offset time.Duration, rootDispersion time.Duration) *Response {
+	p := &Packet{Precision: -20, RootDispersion: uint32(rootDispersion * (1 << 16) / time.Second)}
+	return &Response{Packet: p, Offset: int64(offset)}
+}
+
+func TestSelectTruechimers(t *testing.T) {
+	a := responseWithDistance(10*time.Millisecond, 5*time.Millisecond)
+	b := responseWithDistance(12*time.Millisecond, 5*time.Millisecond)
+	liar := responseWithDistance(500*time.Millisecond, 5*time.Millisecond)
+
+	require.Equal(t, []*Response{a, b}, SelectTruechimers([]*Response{a, liar, b}))
+	require.Equal(t, []*Response{a, b}, SelectTruechimers([]*Response{a, liar, nil, b}))
+}
+
+func TestSelectTruechimersAllAgree(t *testing.T) {
+	a := responseWithDistance(10*time.Millisecond, 5*time.Millisecond)
+	b := responseWithDistance(12*time.Millisecond, 5*time.Millisecond)
+	c := responseWithDistance(8*time.Millisecond, 20*time.Millisecond)
+	require.Equal(t, []*Response{a, b, c}, SelectTruechimers([]*Response{a, b, c}))
+	require.Equal(t, []*Response{a}, SelectTruechimers([]*Response{a}))
+}
+
+func TestSelectTruechimersNoMajority(t *testing.T) {
+	a := responseWithDistance(10*time.Millisecond, time.Millisecond)
+	b := responseWithDistance(100*time.Millisecond, time.Millisecond)
+	require.Nil(t, SelectTruechimers([]*Response{a, b}))
+	require.Nil(t, SelectTruechimers(nil))
+}
+
+func TestCombineOffsets(t *testing.T) {
+	near := responseWithDistance(10*time.Millisecond, time.Millisecond)
+	near.RTT = int64(time.Millisecond)
+	far := responseWithDistance(11*time.Millisecond, 50*time.Millisecond)
+	far.RTT = int64(100 * time.Millisecond)
+	liar := responseWithDistance(time.Second, time.Millisecond)
+
+	combined := CombineOffsets([]*Response{far, liar, near})
+	// low delay server dominates, false ticker is ignored
+	require.Greater(t, combined, int64(10*time.Millisecond))
+	require.Less(t, combined, int64(10100*time.Microsecond))
+
+	// equal distance is a plain average
+	a := responseWithDistance(10*time.Millisecond, 5*time.Millisecond)
+	b := responseWithDistance(12*time.Millisecond, 5*time.Millisecond)
+	require.Equal(t, int64(11*time.Millisecond), CombineOffsets([]*Response{a, b}))
+
+	require.Equal(t, int64(0), CombineOffsets(nil))
+}
+
+func TestFrequencyError(t *testing.T) {
+	start := time.Date(2021, time.June, 1, 12, 0, 0, 0, time.UTC)
+	s1 := &Response{Offset: int64(time.Millisecond), ClientReceiveTime: start}
+	// offset grew by 50µs over 10 seconds: 5 ppm
+	s2 := &Response{Offset: int64(time.Millisecond + 50*time.Microsecond), ClientReceiveTime: start.Add(10 * time.Second)}
+	require.InDelta(t, 5.0, FrequencyError(s1, s2), 1e-9)
+	require.InDelta(t, 5.0, FrequencyError(s2, s1), 1e-9)
+
+	// local clock runs faster
+	s3 := &Response{Offset: int64(time.Millisecond - 100*time.Microsecond), ClientReceiveTime: start.Add(100 * time.Second)}
+	require.InDelta(t, -1.0, FrequencyError(s1, s3), 1e-9)
+
+	require.Equal(t, 0.0, FrequencyError(s1, s1))
+}
@@ -0,0 +1,42 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package protocol
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/facebook/time/timestamp"
+)
+
+// EnableHardwareTimestamps enables hardware RX and TX timestamps for conn on the network interface iface
+// and returns the connection file descriptor to read packets with timestamps from.
+// The NIC must support hardware timestamping.
+// The descriptor is left non-blocking, as net.UDPConn sets it up. ReadNTPPackets, ServeWithTimestamps
+// and PacketStream wait for packets on it, while ReadNTPPacketWithRxTimestamp returns EAGAIN
+// if nothing has arrived yet, unless the descriptor is switched with unix.SetNonblock(connFd, false).
+// The descriptor is owned by conn and is closed with it
+func EnableHardwareTimestamps(conn *net.UDPConn, iface string) (int, error) {
+	connFd, err := timestamp.ConnFd(conn)
+	if err != nil {
+		return -1, err
+	}
+	if err := timestamp.EnableHWTimestamps(connFd, iface); err != nil {
+		return -1, fmt.Errorf("failed to enable hardware timestamps on %s: %w", iface, err)
+	}
+	return connFd, nil
+}
@@ -0,0 +1,136 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package protocol
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// Client is an NTP client which remembers the previous exchange with the server
+// and uses interleaved mode (draft-ietf-ntp-interleaved-modes) if the server supports it.
+//
+// In interleaved mode the request carries receive timestamp of the previous response in Origin
+// and the local receive time of the previous response in Receive timestamp.
+// Interleaving server replies with Origin set to the Receive timestamp of the request
+// and Transmit timestamp set to the precise transmit time of the previous response.
+// Client is not safe for concurrent use
+type Client struct {
+	// previous exchange
+	prevOriginTime        time.Time
+	prevServerReceiveTime time.Time
+	prevServerRx          NTPTimestamp
+	prevClientReceiveTime time.Time
+	prevClientRx          NTPTimestamp
+	hasPrev               bool
+
+	interleaved bool
+}
+
+// SupportsInterleave returns true if the response p to request prev is in interleaved mode:
+// its origin timestamp echoes the receive timestamp of the request rather than the transmit one
+func (p *Packet) SupportsInterleave(prev *Packet) bool {
+	rx := prev.RxTimestamp()
+	return !rx.IsZero() && p.OrigTimestamp() == rx && p.OrigTimestamp() != prev.TxTimestamp()
+}
+
+// NewClient returns new Client
+func NewClient() *Client {
+	return &Client{}
+}
+
+// Interleaved returns true if the last response from the server was in interleaved mode
+func (c *Client) Interleaved() bool {
+	return c.interleaved
+}
+
+// Reset forgets the previous exchange, next request will be in basic mode
+func (c *Client) Reset() {
+	*c = Client{}
+}
+
+// NewRequest returns client request with transmit timestamp set to now
+// and origin and receive timestamps referring to the previous exchange, if any
+func (c *Client) NewRequest() *Packet {
+	p := NewClientPacket()
+	if c.hasPrev {
+		p.SetOrigTimestamp(c.prevServerRx)
+		p.SetRxTimestamp(c.prevClientRx)
+	}
+	return p
+}
+
+// HandleResponse matches response to the request sent and received at clientReceiveTime.
+// In basic mode returned Response describes the current exchange,
+// in interleaved mode it describes the previous exchange with the precise server transmit time
+func (c *Client) HandleResponse(request, response *Packet, clientReceiveTime time.Time) (*Response, error) {
+	originTime := request.TxTimestamp().ToTime()
+	var r *Response
+	switch {
+	case response.OrigTimestamp() == request.TxTimestamp():
+		r = NewResponse(response, originTime, clientReceiveTime)
+		c.interleaved = false
+	case c.hasPrev && response.SupportsInterleave(request):
+		serverTransmitTime := response.TxTimestamp().ToTime()
+		r = &Response{
+			Packet:             response,
+			Offset:             Offset(c.prevOriginTime, c.prevServerReceiveTime, serverTransmitTime, c.prevClientReceiveTime),
+			RTT:                RoundTripDelay(c.prevOriginTime, c.prevServerReceiveTime, serverTransmitTime, c.prevClientReceiveTime),
+			OriginTime:         c.prevOriginTime,
+			ServerReceiveTime:  c.prevServerReceiveTime,
+			ServerTransmitTime: serverTransmitTime,
+			ClientReceiveTime:  c.prevClientReceiveTime,
+		}
+		c.interleaved = true
+	default:
+		return nil, ErrOriginMismatch
+	}
+
+	c.prevOriginTime = originTime
+	c.prevServerRx = response.RxTimestamp()
+	c.prevServerReceiveTime = c.prevServerRx.ToTime()
+	c.prevClientRx.FromTime(clientReceiveTime)
+	c.prevClientReceiveTime = clientReceiveTime
+	c.hasPrev = true
+	return r, nil
+}
+
+// Query sends a request to address (host:port) and returns the matched Response
+func (c *Client) Query(address string, timeout time.Duration) (*Response, error) {
+	conn, err := net.DialTimeout("udp", address, timeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", address, err)
+	}
+	defer conn.Close()
+
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return nil, err
+	}
+
+	request := c.NewRequest()
+	response, clientReceiveTime, err := roundTrip(conn, request, nil)
+	if err != nil {
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			return nil, fmt.Errorf("%w %s for %v", ErrTimeout, address, timeout)
+		}
+		return nil, err
+	}
+	return c.HandleResponse(request, response, clientReceiveTime)
+}
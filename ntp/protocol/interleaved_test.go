@@ -0,0 +1,133 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package protocol
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeInterleavedServer is a server supporting interleaved mode for a single client
+type fakeInterleavedServer struct {
+	lastRx        NTPTimestamp
+	lastPreciseTx time.Time
+}
+
+// handle replies to req received at rxTime. Reply is stamped with imprecise txTime,
+// and departs at preciseTxTime which is sent in the next interleaved response
+func (s *fakeInterleavedServer) handle(req *Packet, rxTime, txTime, preciseTxTime time.Time) *Packet {
+	var response *Packet
+	if !s.lastRx.IsZero() && req.OrigTimestamp() == s.lastRx && req.OrigTimestamp() != req.TxTimestamp() {
+		response = NewServerResponse(req, rxTime, s.lastPreciseTx, 1, 0)
+		response.SetOrigTimestamp(req.RxTimestamp())
+	} else {
+		response = NewServerResponse(req, rxTime, txTime, 1, 0)
+	}
+	s.lastRx.FromTime(rxTime)
+	s.lastPreciseTx = preciseTxTime
+	return response
+}
+
+func TestClientInterleaved(t *testing.T) {
+	serverOffset := time.Hour
+	c := NewClient()
+	s := &fakeInterleavedServer{}
+
+	exchange := func() *Response {
+		request := c.NewRequest()
+		t1 := request.TxTimestamp().ToTime()
+		rx := t1.Add(forwardDelay + serverOffset)
+		// server stamps transmit time 1ms early
+		tx := rx.Add(time.Millisecond)
+		preciseTx := rx.Add(2 * time.Millisecond)
+		t4 := preciseTx.Add(returnDelay - serverOffset)
+
+		response := s.handle(request, rx, tx, preciseTx)
+		r, err := c.HandleResponse(request, response, t4)
+		require.NoError(t, err)
+		return r
+	}
+
+	// first exchange is always basic and affected by imprecise transmit timestamp
+	r := exchange()
+	require.False(t, c.Interleaved())
+	expected := serverOffset - (returnDelay-forwardDelay+time.Millisecond)/2
+	require.InDelta(t, expected.Nanoseconds(), r.Offset, float64(time.Microsecond))
+
+	// second exchange is interleaved and refers to the first one
+	first := r
+	r = exchange()
+	require.True(t, c.Interleaved())
+	require.Equal(t, first.OriginTime, r.OriginTime)
+	require.Equal(t, first.ServerReceiveTime, r.ServerReceiveTime)
+	require.Equal(t, first.ClientReceiveTime, r.ClientReceiveTime)
+	require.InDelta(t, first.ServerReceiveTime.Add(2*time.Millisecond).UnixNano(), r.ServerTransmitTime.UnixNano(), 1)
+	expected = serverOffset - (returnDelay-forwardDelay)/2
+	require.InDelta(t, expected.Nanoseconds(), r.Offset, float64(time.Microsecond))
+	require.InDelta(t, (forwardDelay + returnDelay).Nanoseconds(), r.RTT, float64(time.Microsecond))
+
+	c.Reset()
+	require.False(t, c.Interleaved())
+	require.True(t, c.NewRequest().OrigTimestamp().IsZero())
+}
+
+func TestClientBasic(t *testing.T) {
+	conn := startFakeServer(t, 0)
+	defer conn.Close()
+
+	c := NewClient()
+	for i := 0; i < 2; i++ {
+		r, err := c.Query(conn.LocalAddr().String(), time.Second)
+		require.NoError(t, err)
+		require.False(t, c.Interleaved())
+		require.Equal(t, uint8(1), r.Packet.Stratum)
+	}
+}
+
+func TestClientOriginMismatch(t *testing.T) {
+	c := NewClient()
+	request := c.NewRequest()
+	response := NewServerResponse(request, time.Now(), time.Now(), 1, 0)
+	response.OrigTimeFrac++
+
+	_, err := c.HandleResponse(request, response, time.Now())
+	require.ErrorIs(t, err, ErrOriginMismatch)
+}
+
+func TestSupportsInterleave(t *testing.T) {
+	c := NewClient()
+	s := &fakeInterleavedServer{}
+	now := time.Now()
+
+	// basic mode: first request has nothing to interleave with
+	request := c.NewRequest()
+	response := s.handle(request, now, now, now)
+	require.False(t, response.SupportsInterleave(request))
+	_, err := c.HandleResponse(request, response, now)
+	require.NoError(t, err)
+
+	// interleaved mode
+	request = c.NewRequest()
+	response = s.handle(request, now, now, now)
+	require.True(t, response.SupportsInterleave(request))
+
+	// basic server ignores the hint and echoes transmit timestamp
+	response = NewServerResponse(request, now, now, 1, 0)
+	require.False(t, response.SupportsInterleave(request))
+}
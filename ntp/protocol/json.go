@@ -0,0 +1,116 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package protocol
+
+import (
+	"encoding/json"
+	"math"
+	"time"
+)
+
+// packetJSON is a decoded representation of the Packet used for JSON encoding
+type packetJSON struct {
+	Leap           uint8     `json:"leap"`
+	Version        uint8     `json:"version"`
+	Mode           uint8     `json:"mode"`
+	Stratum        uint8     `json:"stratum"`
+	Poll           string    `json:"poll"`
+	PollExponent   *int8     `json:"poll_exponent,omitempty"`
+	Precision      int8      `json:"precision"`
+	RootDelay      string    `json:"root_delay"`
+	RootDispersion string    `json:"root_dispersion"`
+	ReferenceID    uint32    `json:"reference_id"`
+	RefID          string    `json:"ref_id"`
+	RefTime        time.Time `json:"ref_time"`
+	OrigTime       time.Time `json:"orig_time"`
+	RxTime         time.Time `json:"rx_time"`
+	TxTime         time.Time `json:"tx_time"`
+}
+
+// durationToShort converts time.Duration into NTP short format (16.16 seconds).
+// It rounds up so it's an exact inverse of shortToDuration
+func durationToShort(d time.Duration) uint32 {
+	if d <= 0 {
+		return 0
+	}
+	return uint32((uint64(d)<<16 + uint64(time.Second) - 1) / uint64(time.Second))
+}
+
+// MarshalJSON encodes the Packet as JSON with decoded fields
+func (p *Packet) MarshalJSON() ([]byte, error) {
+	// poll is clamped to 1s-2^17s, the raw exponent keeps sub-second polling intact
+	pollExponent := p.Poll
+	return json.Marshal(packetJSON{
+		Leap:           p.LeapIndicator(),
+		Version:        p.Version(),
+		Mode:           p.Mode(),
+		Stratum:        p.Stratum,
+		Poll:           p.PollInterval().String(),
+		PollExponent:   &pollExponent,
+		Precision:      p.Precision,
+		RootDelay:      p.RootDelayDuration().String(),
+		RootDispersion: p.RootDispersionDuration().String(),
+		ReferenceID:    p.ReferenceID,
+		RefID:          p.refIDText(),
+		RefTime:        p.RefTimestamp().ToTime().UTC(),
+		OrigTime:       p.OrigTimestamp().ToTime().UTC(),
+		RxTime:         p.RxTimestamp().ToTime().UTC(),
+		TxTime:         p.TxTimestamp().ToTime().UTC(),
+	})
+}
+
+// UnmarshalJSON fills the Packet from JSON produced by MarshalJSON
+func (p *Packet) UnmarshalJSON(b []byte) error {
+	var j packetJSON
+	if err := json.Unmarshal(b, &j); err != nil {
+		return err
+	}
+	poll, err := time.ParseDuration(j.Poll)
+	if err != nil {
+		return err
+	}
+	rootDelay, err := time.ParseDuration(j.RootDelay)
+	if err != nil {
+		return err
+	}
+	rootDispersion, err := time.ParseDuration(j.RootDispersion)
+	if err != nil {
+		return err
+	}
+
+	*p = Packet{
+		Stratum:        j.Stratum,
+		Precision:      j.Precision,
+		RootDelay:      durationToShort(rootDelay),
+		RootDispersion: durationToShort(rootDispersion),
+		ReferenceID:    j.ReferenceID,
+	}
+	if j.PollExponent != nil {
+		p.Poll = *j.PollExponent
+	} else if poll > 0 {
+		// JSON without the exponent
+		p.Poll = int8(math.Round(math.Log2(poll.Seconds())))
+	}
+	p.SetLeapIndicator(j.Leap)
+	p.SetVersion(j.Version)
+	p.SetMode(j.Mode)
+	p.SetReferenceTime(j.RefTime)
+	p.SetOriginTime(j.OrigTime)
+	p.SetReceiveTime(j.RxTime)
+	p.SetTransmitTime(j.TxTime)
+	return nil
+}
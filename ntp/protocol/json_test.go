@@ -0,0 +1,89 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package protocol
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPacketJSON(t *testing.T) {
+	b, err := json.Marshal(ntpResponse)
+	require.NoError(t, err)
+	expected := `{"leap":0,"version":4,"mode":4,"stratum":1,"poll":"8s","poll_exponent":3,"precision":-32,` +
+		`"root_delay":"0s","root_dispersion":"152.587µs","reference_id":1178738720,"ref_id":"FB",` +
+		`"ref_time":"2020-03-26T11:10:00Z","orig_time":"2020-03-26T11:24:39.632884075Z",` +
+		`"rx_time":"2020-03-26T11:24:39.632921111Z","tx_time":"2020-03-26T11:24:39.633241953Z"}`
+	require.Equal(t, expected, string(b))
+
+	decoded := &Packet{}
+	err = json.Unmarshal(b, decoded)
+	require.NoError(t, err)
+	require.Equal(t, ntpResponse.Settings, decoded.Settings)
+	require.Equal(t, ntpResponse.Stratum, decoded.Stratum)
+	require.Equal(t, ntpResponse.Poll, decoded.Poll)
+	require.Equal(t, ntpResponse.RootDispersion, decoded.RootDispersion)
+	require.Equal(t, ntpResponse.ReferenceID, decoded.ReferenceID)
+	require.Equal(t, ntpResponse.TxTimestamp().ToTime(), decoded.TxTimestamp().ToTime())
+
+	// decoded view is the same
+	again, err := json.Marshal(decoded)
+	require.NoError(t, err)
+	require.Equal(t, string(b), string(again))
+
+	// wire format is unaffected
+	wire, err := decoded.Bytes()
+	require.NoError(t, err)
+	require.Equal(t, PacketSizeBytes, len(wire))
+}
+
+func TestPacketJSONPoll(t *testing.T) {
+	for _, poll := range []int8{-7, -1, 0, 6, 17, 24} {
+		p := *ntpResponse
+		p.Poll = poll
+		b, err := json.Marshal(&p)
+		require.NoError(t, err)
+
+		decoded := &Packet{}
+		require.NoError(t, json.Unmarshal(b, decoded))
+		require.Equal(t, poll, decoded.Poll, "poll %d", poll)
+	}
+
+	// without the exponent poll is taken from the duration
+	decoded := &Packet{}
+	require.NoError(t, json.Unmarshal([]byte(`{"poll":"64s","root_delay":"0s","root_dispersion":"0s"}`), decoded))
+	require.Equal(t, int8(6), decoded.Poll)
+	require.NoError(t, json.Unmarshal([]byte(`{"poll":"500ms","root_delay":"0s","root_dispersion":"0s"}`), decoded))
+	require.Equal(t, int8(-1), decoded.Poll)
+}
+
+func TestPacketUnmarshalJSONError(t *testing.T) {
+	p := &Packet{}
+	require.Error(t, json.Unmarshal([]byte(`{"poll":"forever"}`), p))
+	require.Error(t, json.Unmarshal([]byte(`[]`), p))
+}
+
+func TestDurationToShort(t *testing.T) {
+	for _, v := range []uint32{0, 1, 10, 65535, 65536, 1 << 20, math.MaxUint32} {
+		require.Equal(t, v, durationToShort(shortToDuration(v)))
+	}
+	require.Equal(t, uint32(0), durationToShort(-time.Second))
+}
@@ -0,0 +1,194 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package protocol
+
+import (
+	"crypto/aes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Supported symmetric key MAC algorithms
+const (
+	// MACAlgoMD5 is a legacy MD5 keyed digest as per RFC 5905
+	MACAlgoMD5 = "MD5"
+	// MACAlgoSHA1 is a SHA-1 keyed digest
+	MACAlgoSHA1 = "SHA1"
+	// MACAlgoAESCMAC is AES-CMAC as per RFC 8573
+	MACAlgoAESCMAC = "AES-CMAC"
+)
+
+const (
+	keyIDSizeBytes = 4
+	// cryptoNAKSizeBytes is a MAC consisting of zero key ID only
+	cryptoNAKSizeBytes = keyIDSizeBytes
+)
+
+// ErrNoMAC is returned when Packet has no MAC to verify
+var ErrNoMAC = errors.New("packet has no MAC")
+
+// AppendMAC signs the header and extension fields of the Packet with the key using algo
+// and appends key ID and the digest to the Packet
+func (p *Packet) AppendMAC(keyID uint32, key []byte, algo string) error {
+	b, err := p.signedBytes()
+	if err != nil {
+		return err
+	}
+	digest, err := macDigest(algo, key, b)
+	if err != nil {
+		return err
+	}
+	mac := make([]byte, keyIDSizeBytes+len(digest))
+	binary.BigEndian.PutUint32(mac, keyID)
+	copy(mac[keyIDSizeBytes:], digest)
+	p.mac = mac
+	return nil
+}
+
+// MACKeyID returns key ID of the MAC and whether Packet has MAC at all
+func (p *Packet) MACKeyID() (uint32, bool) {
+	if len(p.mac) < keyIDSizeBytes {
+		return 0, false
+	}
+	return binary.BigEndian.Uint32(p.mac), true
+}
+
+// VerifyMAC checks the MAC of the Packet against the key.
+// Algorithm is derived from the digest length, 16 bytes digest may be MD5 or AES-CMAC
+func (p *Packet) VerifyMAC(key []byte) (bool, error) {
+	if len(p.mac) <= keyIDSizeBytes {
+		return false, ErrNoMAC
+	}
+	digest := p.mac[keyIDSizeBytes:]
+
+	var algos []string
+	switch len(digest) {
+	case md5.Size:
+		algos = []string{MACAlgoMD5, MACAlgoAESCMAC}
+	case sha1.Size:
+		algos = []string{MACAlgoSHA1}
+	default:
+		return false, fmt.Errorf("unsupported digest length %d", len(digest))
+	}
+
+	b, err := p.signedBytes()
+	if err != nil {
+		return false, err
+	}
+
+	for _, algo := range algos {
+		expected, err := macDigest(algo, key, b)
+		if err != nil {
+			// AES-CMAC requires key of specific length
+			continue
+		}
+		if subtle.ConstantTimeCompare(expected, digest) == 1 {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// signedBytes returns the part of the Packet covered by MAC: header and extension fields
+// laid out as they are followed by MAC
+func (p *Packet) signedBytes() ([]byte, error) {
+	b := make([]byte, PacketSizeBytes+p.extensionsSize(true))
+	n, err := p.marshalUnsignedTo(b, true)
+	return b[:n], err
+}
+
+// macDigest computes digest of b with algo
+func macDigest(algo string, key, b []byte) ([]byte, error) {
+	switch algo {
+	case MACAlgoMD5:
+		h := md5.New()
+		h.Write(key)
+		h.Write(b)
+		return h.Sum(nil), nil
+	case MACAlgoSHA1:
+		h := sha1.New()
+		h.Write(key)
+		h.Write(b)
+		return h.Sum(nil), nil
+	case MACAlgoAESCMAC:
+		return aesCMAC(key, b)
+	}
+	return nil, fmt.Errorf("unsupported MAC algorithm %q", algo)
+}
+
+// aesCMAC implements AES-CMAC as per RFC 4493
+func aesCMAC(key, msg []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	bs := block.BlockSize()
+
+	// generate subkeys
+	k1 := make([]byte, bs)
+	block.Encrypt(k1, k1)
+	k1 = cmacDouble(k1)
+	k2 := cmacDouble(k1)
+
+	n := (len(msg) + bs - 1) / bs
+	complete := n > 0 && len(msg)%bs == 0
+	if n == 0 {
+		n = 1
+	}
+
+	last := make([]byte, bs)
+	copy(last, msg[(n-1)*bs:])
+	subkey := k1
+	if !complete {
+		last[len(msg)-(n-1)*bs] = 0x80
+		subkey = k2
+	}
+	for i := range last {
+		last[i] ^= subkey[i]
+	}
+
+	x := make([]byte, bs)
+	for i := 0; i < n-1; i++ {
+		for j := 0; j < bs; j++ {
+			x[j] ^= msg[i*bs+j]
+		}
+		block.Encrypt(x, x)
+	}
+	for j := 0; j < bs; j++ {
+		x[j] ^= last[j]
+	}
+	block.Encrypt(x, x)
+	return x, nil
+}
+
+// cmacDouble multiplies b by x in GF(2^128)
+func cmacDouble(b []byte) []byte {
+	out := make([]byte, len(b))
+	var carry byte
+	for i := len(b) - 1; i >= 0; i-- {
+		out[i] = b[i]<<1 | carry
+		carry = b[i] >> 7
+	}
+	if carry != 0 {
+		out[len(out)-1] ^= 0x87
+	}
+	return out
+}
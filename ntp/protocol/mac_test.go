@@ -0,0 +1,135 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package protocol
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+var macKey = []byte("secret")
+
+func TestAppendMACMD5(t *testing.T) {
+	p := *ntpRequest
+	err := p.AppendMAC(42, macKey, MACAlgoMD5)
+	require.NoError(t, err)
+
+	b, err := p.Bytes()
+	require.NoError(t, err)
+	require.Equal(t, PacketSizeBytes+20, len(b))
+	require.Equal(t, ntpRequestBytes, b[:PacketSizeBytes])
+	// key ID
+	require.Equal(t, []byte{0, 0, 0, 42}, b[PacketSizeBytes:PacketSizeBytes+4])
+	// MD5(key || packet)
+	require.Equal(t, "869c3fa1cf9e664f988cf94e85af099e", hex.EncodeToString(b[PacketSizeBytes+4:]))
+
+	decoded, err := BytesToPacket(b)
+	require.NoError(t, err)
+	keyID, ok := decoded.MACKeyID()
+	require.True(t, ok)
+	require.Equal(t, uint32(42), keyID)
+
+	valid, err := decoded.VerifyMAC(macKey)
+	require.NoError(t, err)
+	require.True(t, valid)
+
+	valid, err = decoded.VerifyMAC([]byte("wrong"))
+	require.NoError(t, err)
+	require.False(t, valid)
+}
+
+func TestAppendMACSHA1(t *testing.T) {
+	p := *ntpRequest
+	err := p.AppendMAC(1, macKey, MACAlgoSHA1)
+	require.NoError(t, err)
+
+	b, err := p.Bytes()
+	require.NoError(t, err)
+	require.Equal(t, PacketSizeBytes+24, len(b))
+	// SHA1(key || packet)
+	require.Equal(t, "c71cfc35c65968ba1fd142cb69d1c82dcc0f534a", hex.EncodeToString(b[PacketSizeBytes+4:]))
+
+	decoded, err := BytesToPacket(b)
+	require.NoError(t, err)
+	valid, err := decoded.VerifyMAC(macKey)
+	require.NoError(t, err)
+	require.True(t, valid)
+}
+
+func TestAppendMACAESCMACWithExtensions(t *testing.T) {
+	key, err := hex.DecodeString("2b7e151628aed2a6abf7158809cf4f3c")
+	require.NoError(t, err)
+
+	p := *ntpRequest
+	p.AppendExtension(ExtensionField{Type: 0x0104, Value: make([]byte, 28)})
+	err = p.AppendMAC(7, key, MACAlgoAESCMAC)
+	require.NoError(t, err)
+
+	b, err := p.Bytes()
+	require.NoError(t, err)
+	require.Equal(t, PacketSizeBytes+32+20, len(b))
+
+	decoded, err := BytesToPacket(b)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(decoded.Extensions()))
+	valid, err := decoded.VerifyMAC(key)
+	require.NoError(t, err)
+	require.True(t, valid)
+
+	// tampering with the header invalidates MAC
+	decoded.Stratum = 1
+	valid, err = decoded.VerifyMAC(key)
+	require.NoError(t, err)
+	require.False(t, valid)
+}
+
+func TestAppendMACUnsupported(t *testing.T) {
+	p := *ntpRequest
+	err := p.AppendMAC(1, macKey, "CRC32")
+	require.Error(t, err)
+	_, ok := p.MACKeyID()
+	require.False(t, ok)
+}
+
+func TestVerifyMACNoMAC(t *testing.T) {
+	_, err := ntpResponse.VerifyMAC(macKey)
+	require.ErrorIs(t, err, ErrNoMAC)
+}
+
+func TestAESCMAC(t *testing.T) {
+	// RFC 4493 test vectors
+	key, err := hex.DecodeString("2b7e151628aed2a6abf7158809cf4f3c")
+	require.NoError(t, err)
+	msg, err := hex.DecodeString("6bc1bee22e409f96e93d7e117393172aae2d8a571e03ac9c9eb76fac45af8e5130c81c46a35ce411")
+	require.NoError(t, err)
+
+	tests := []struct {
+		length int
+		mac    string
+	}{
+		{length: 0, mac: "bb1d6929e95937287fa37d129b756746"},
+		{length: 16, mac: "070a16b46b4d4144f79bdd9dd04a287c"},
+		{length: 40, mac: "dfa66747de9ae63030ca32611497c827"},
+	}
+	for _, tt := range tests {
+		mac, err := aesCMAC(key, msg[:tt.length])
+		require.NoError(t, err)
+		require.Equal(t, tt.mac, hex.EncodeToString(mac), "length %d", tt.length)
+	}
+}
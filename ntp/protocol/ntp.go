@@ -30,20 +30,57 @@ import (
 // Formula is 70 * (365 + 17) * 86400 (17 leap days)
 const NanosecondsToUnix = int64(2_208_988_800_000_000_000)
 
-// Time is converting Unix time to sec and frac NTP format
+// Time is converting Unix time to sec and frac NTP format.
+// Seconds wrap around at the end of each NTP Era (Feb-7 2036 for Era 0), use TimeEra to get the Era too
 func Time(t time.Time) (seconds uint32, fracions uint32) {
+	seconds, fracions, _ = TimeEra(t)
+	return seconds, fracions
+}
+
+// TimeEra is converting Unix time to sec and frac NTP format along with the NTP Era they belong to.
+// UnixEra is the inverse
+func TimeEra(t time.Time) (seconds uint32, fractions uint32, era int) {
 	nsec := t.UnixNano() + NanosecondsToUnix
 	sec := nsec / time.Second.Nanoseconds()
-	return uint32(sec), uint32((nsec - sec*time.Second.Nanoseconds()) << 32 / time.Second.Nanoseconds())
+	return uint32(sec), uint32((nsec - sec*time.Second.Nanoseconds()) << 32 / time.Second.Nanoseconds()), int(sec >> 32)
 }
 
-// Unix is converting NTP seconds and fractions into Unix time
+// Unix is converting NTP seconds and fractions into Unix time.
+// It assumes NTP Era 0 and is the same as UnixEra(seconds, fractions, 0)
 func Unix(seconds, fractions uint32) time.Time {
-	secs := int64(seconds) - NanosecondsToUnix/time.Second.Nanoseconds()
-	nanos := (int64(fractions) * time.Second.Nanoseconds()) >> 32 // convert fractional to nanos
+	return UnixEra(seconds, fractions, 0)
+}
+
+// UnixEra is converting NTP seconds and fractions of the given NTP Era into Unix time.
+// Era 0 started Jan-1 1900 and ends Feb-7 2036, each era is 2^32 seconds long
+func UnixEra(seconds, fractions uint32, era int) time.Time {
+	secs := int64(era)<<32 + int64(seconds) - NanosecondsToUnix/time.Second.Nanoseconds()
+	// convert fractional to nanos, rounding to the nearest nanosecond
+	nanos := (int64(fractions)*time.Second.Nanoseconds() + 1<<31) >> 32
 	return time.Unix(secs, nanos)
 }
 
+// NTPTimestamp is a 64 bit NTP timestamp: seconds and fractions since the start of NTP Era 0
+type NTPTimestamp struct {
+	Seconds  uint32
+	Fraction uint32
+}
+
+// ToTime converts NTPTimestamp into Unix time
+func (t NTPTimestamp) ToTime() time.Time {
+	return Unix(t.Seconds, t.Fraction)
+}
+
+// FromTime sets NTPTimestamp from Unix time
+func (t *NTPTimestamp) FromTime(tt time.Time) {
+	t.Seconds, t.Fraction = Time(tt)
+}
+
+// IsZero returns true if both seconds and fractions are zero
+func (t NTPTimestamp) IsZero() bool {
+	return t.Seconds == 0 && t.Fraction == 0
+}
+
 // Offset uses NTP algorithm for clock offset
 func Offset(originTime, serverReceiveTime, serverTransmitTime, clientReceiveTime time.Time) int64 {
 	outboundClockDelta := serverReceiveTime.Sub(originTime).Nanoseconds()
@@ -52,6 +89,18 @@ func Offset(originTime, serverReceiveTime, serverTransmitTime, clientReceiveTime
 	return (outboundClockDelta + inboundClockDelta) / 2
 }
 
+// OffsetDuration is the same as Offset but returns time.Duration
+func OffsetDuration(originTime, serverReceiveTime, serverTransmitTime, clientReceiveTime time.Time) time.Duration {
+	return time.Duration(Offset(originTime, serverReceiveTime, serverTransmitTime, clientReceiveTime))
+}
+
+// OffsetWithAsymmetry uses NTP algorithm for clock offset correcting it by known network asymmetry.
+// asymmetry is the forward (client -> server) minus the return (server -> client) one way delay,
+// so it's positive when requests take longer to reach the server than replies take to come back
+func OffsetWithAsymmetry(originTime, serverReceiveTime, serverTransmitTime, clientReceiveTime time.Time, asymmetry time.Duration) int64 {
+	return Offset(originTime, serverReceiveTime, serverTransmitTime, clientReceiveTime) - asymmetry.Nanoseconds()/2
+}
+
 // RoundTripDelay uses NTP algorithm for roundtrip network delay
 func RoundTripDelay(originTime, serverReceiveTime, serverTransmitTime, clientReceiveTime time.Time) int64 {
 	totalDelay := clientReceiveTime.Sub(originTime).Nanoseconds()
@@ -60,9 +109,25 @@ func RoundTripDelay(originTime, serverReceiveTime, serverTransmitTime, clientRec
 	return (totalDelay - serverDelay)
 }
 
+// RoundTripDelayDuration is the same as RoundTripDelay but returns time.Duration
+func RoundTripDelayDuration(originTime, serverReceiveTime, serverTransmitTime, clientReceiveTime time.Time) time.Duration {
+	return time.Duration(RoundTripDelay(originTime, serverReceiveTime, serverTransmitTime, clientReceiveTime))
+}
+
 // CorrectTime returns the correct time based on computed offset
 func CorrectTime(clientReceiveTime time.Time, offset int64) time.Time {
 	correctTime := clientReceiveTime.Add(time.Duration(offset))
 
 	return correctTime
 }
+
+// CorrectTimeDuration is the same as CorrectTime but takes offset as time.Duration
+func CorrectTimeDuration(clientReceiveTime time.Time, offset time.Duration) time.Time {
+	return CorrectTime(clientReceiveTime, offset.Nanoseconds())
+}
+
+// CorrectTimeWithLeap returns the correct time based on computed offset
+// and whether leap indicator li announces a leap second at the end of the day
+func CorrectTimeWithLeap(clientReceiveTime time.Time, offset int64, li uint8) (time.Time, bool) {
+	return CorrectTime(clientReceiveTime, offset), li == liLastMinute61 || li == liLastMinute59
+}
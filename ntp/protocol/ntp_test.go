@@ -17,6 +17,7 @@ limitations under the License.
 package protocol
 
 import (
+	"encoding/binary"
 	"net"
 	"testing"
 	"time"
@@ -106,12 +107,57 @@ func TestResponseConversion(t *testing.T) {
 	require.Equal(t, ntpResponseBytes, bytes)
 }
 
+func TestMarshalTo(t *testing.T) {
+	b := make([]byte, PacketSizeBytes+10)
+	n, err := ntpResponse.MarshalTo(b)
+	require.NoError(t, err)
+	require.Equal(t, PacketSizeBytes, n)
+	require.Equal(t, ntpResponseBytes, b[:n])
+
+	n, err = ntpRequest.MarshalTo(b)
+	require.NoError(t, err)
+	require.Equal(t, ntpRequestBytes, b[:n])
+}
+
+func TestMarshalToShortBuffer(t *testing.T) {
+	b := make([]byte, PacketSizeBytes-1)
+	_, err := ntpResponse.MarshalTo(b)
+	require.Error(t, err)
+}
+
 func TestBytesToPacket(t *testing.T) {
 	packet, err := BytesToPacket(ntpResponseBytes)
 	require.NoError(t, err)
 	require.Equal(t, ntpResponse, packet)
 }
 
+func TestUnmarshalBinaryDirty(t *testing.T) {
+	packet := &Packet{
+		Settings:       1,
+		Stratum:        2,
+		Poll:           3,
+		Precision:      4,
+		RootDelay:      5,
+		RootDispersion: 6,
+		ReferenceID:    7,
+		RefTimeSec:     8,
+		RefTimeFrac:    9,
+		OrigTimeSec:    10,
+		OrigTimeFrac:   11,
+		RxTimeSec:      12,
+		RxTimeFrac:     13,
+		TxTimeSec:      14,
+		TxTimeFrac:     15,
+	}
+	err := packet.UnmarshalBinary(ntpResponseBytes)
+	require.NoError(t, err)
+	require.Equal(t, ntpResponse, packet)
+
+	err = packet.UnmarshalBinary(ntpRequestBytes)
+	require.NoError(t, err)
+	require.Equal(t, ntpRequest, packet)
+}
+
 func TestBytesToPacketError(t *testing.T) {
 	bytes := []byte{}
 	packet, err := BytesToPacket(bytes)
@@ -119,6 +165,50 @@ func TestBytesToPacketError(t *testing.T) {
 	require.Equal(t, &Packet{}, packet)
 }
 
+func TestBytesToPacketShort(t *testing.T) {
+	packet, err := BytesToPacket(ntpResponseBytes[:PacketSizeBytes-1])
+	require.ErrorIs(t, err, ErrShortPacket)
+	require.NotNil(t, packet)
+
+	_, err = BytesToPacket([]byte{})
+	require.ErrorIs(t, err, ErrShortPacket)
+}
+
+func TestBytesToPacketWithExtensions(t *testing.T) {
+	trailer := []byte{0, 1, 0, 20, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
+	b := append(append([]byte{}, ntpResponseBytes...), trailer...)
+
+	packet, ext, err := BytesToPacketWithExtensions(b)
+	require.NoError(t, err)
+	// 20 bytes following the header form a valid extension field of type 1, not a MAC
+	expected := *ntpResponse
+	expected.extensions = []ExtensionField{{Type: 1, Length: 20, Value: trailer[4:]}}
+	require.Equal(t, &expected, packet)
+	require.Nil(t, packet.mac)
+	require.Equal(t, trailer, ext)
+
+	// same field followed by another one
+	last := append([]byte{0, 2, 0, 28}, make([]byte, 24)...)
+	b = append(b, last...)
+	packet, ext, err = BytesToPacketWithExtensions(b)
+	require.NoError(t, err)
+	require.Len(t, packet.Extensions(), 2)
+	require.Equal(t, expected.extensions[0], packet.Extensions()[0])
+	require.Equal(t, uint16(2), packet.Extensions()[1].Type)
+	require.Nil(t, packet.mac)
+	require.Equal(t, append(append([]byte{}, trailer...), last...), ext)
+}
+
+func TestBytesToPacketWithExtensionsNone(t *testing.T) {
+	packet, ext, err := BytesToPacketWithExtensions(ntpResponseBytes)
+	require.NoError(t, err)
+	require.Equal(t, ntpResponse, packet)
+	require.Empty(t, ext)
+
+	_, _, err = BytesToPacketWithExtensions(ntpResponseBytes[:10])
+	require.ErrorIs(t, err, ErrShortPacket)
+}
+
 // Testing conversion so if Packet structure changes we notice
 func TestPacketConversionFailure(t *testing.T) {
 	bytes, err := ntpRequest.Bytes()
@@ -142,6 +232,305 @@ func TestInvalidSettingsFormat(t *testing.T) {
 	require.False(t, ntpBadRequest.ValidSettingsFormat())
 }
 
+func TestSettingsAccessorsRequest(t *testing.T) {
+	require.Equal(t, uint8(3), ntpRequest.LeapIndicator())
+	require.Equal(t, uint8(4), ntpRequest.Version())
+	require.Equal(t, uint8(3), ntpRequest.Mode())
+}
+
+func TestSettingsAccessorsResponse(t *testing.T) {
+	packet, err := BytesToPacket(ntpResponseBytes)
+	require.NoError(t, err)
+	require.Equal(t, uint8(0), packet.LeapIndicator())
+	require.Equal(t, uint8(4), packet.Version())
+	require.Equal(t, uint8(4), packet.Mode())
+}
+
+func TestSettingsSetters(t *testing.T) {
+	p := &Packet{}
+	p.SetLeapIndicator(3)
+	p.SetVersion(4)
+	p.SetMode(3)
+	require.Equal(t, ntpRequest.Settings, p.Settings)
+	require.Equal(t, uint8(227), p.Settings)
+}
+
+func TestSettingsSettersMasking(t *testing.T) {
+	p := &Packet{Settings: 227}
+	p.SetVersion(0xff)
+	require.Equal(t, uint8(3), p.LeapIndicator())
+	require.Equal(t, uint8(7), p.Version())
+	require.Equal(t, uint8(3), p.Mode())
+
+	p.SetMode(0xfc)
+	require.Equal(t, uint8(3), p.LeapIndicator())
+	require.Equal(t, uint8(7), p.Version())
+	require.Equal(t, uint8(4), p.Mode())
+
+	p.SetLeapIndicator(0xfd)
+	require.Equal(t, uint8(1), p.LeapIndicator())
+	require.Equal(t, uint8(7), p.Version())
+	require.Equal(t, uint8(4), p.Mode())
+}
+
+func TestValidate(t *testing.T) {
+	require.NoError(t, ntpResponse.Validate())
+	require.NoError(t, ntpRequest.Validate())
+}
+
+func TestValidateFailures(t *testing.T) {
+	p := *ntpResponse
+	p.Stratum = 200
+	require.ErrorIs(t, p.Validate(), ErrInvalidStratum)
+
+	p = *ntpResponse
+	p.SetMode(0)
+	require.ErrorIs(t, p.Validate(), ErrInvalidMode)
+
+	p = *ntpResponse
+	p.SetVersion(0)
+	require.ErrorIs(t, p.Validate(), ErrInvalidVersion)
+
+	p = *ntpResponse
+	p.TxTimeSec = 0
+	p.TxTimeFrac = 0
+	require.ErrorIs(t, p.Validate(), ErrZeroTransmitTime)
+}
+
+func TestValidateRequest(t *testing.T) {
+	require.NoError(t, NewClientPacket().ValidateRequest())
+	require.NoError(t, ntpRequest.ValidateRequest())
+	require.ErrorIs(t, ntpBadRequest.ValidateRequest(), ErrInvalidMode)
+}
+
+func TestValidateRequestFailures(t *testing.T) {
+	p := NewClientPacket()
+	p.SetMode(modeServer)
+	require.ErrorIs(t, p.ValidateRequest(), ErrInvalidMode)
+
+	p = NewClientPacket()
+	p.SetMode(modeSymActive)
+	require.NoError(t, p.ValidateRequest())
+
+	p = NewClientPacket()
+	p.SetVersion(2)
+	require.ErrorIs(t, p.ValidateRequest(), ErrInvalidVersion)
+
+	p = NewClientPacket()
+	p.SetVersion(vnV5)
+	require.NoError(t, p.ValidateRequest())
+
+	p = NewClientPacket()
+	p.SetVersion(6)
+	require.ErrorIs(t, p.ValidateRequest(), ErrInvalidVersion)
+
+	p = NewClientPacket()
+	p.TxTimeSec = 0
+	p.TxTimeFrac = 0
+	require.ErrorIs(t, p.ValidateRequest(), ErrZeroTransmitTime)
+}
+
+func TestValidTimestampOrder(t *testing.T) {
+	t1 := ntpResponse.OrigTimestamp().ToTime()
+	t4 := t1.Add(10 * time.Millisecond)
+	require.NoError(t, ntpResponse.ValidTimestampOrder(t1, t4))
+
+	// origin doesn't match
+	require.ErrorIs(t, ntpResponse.ValidTimestampOrder(t1.Add(time.Second), t4.Add(time.Second)), ErrOriginMismatch)
+
+	// received after sent
+	p := *ntpResponse
+	p.SetRxTimestamp(p.TxTimestamp())
+	p.RxTimeSec++
+	err := p.ValidTimestampOrder(t1, t4)
+	require.ErrorIs(t, err, ErrTimestampOrder)
+	require.Contains(t, err.Error(), "receive time")
+
+	// reply came before the request
+	err = ntpResponse.ValidTimestampOrder(t1, t1.Add(-time.Millisecond))
+	require.ErrorIs(t, err, ErrTimestampOrder)
+
+	// server held the packet longer than the whole exchange took
+	err = ntpResponse.ValidTimestampOrder(t1, t1.Add(time.Microsecond))
+	require.ErrorIs(t, err, ErrTimestampOrder)
+	require.Contains(t, err.Error(), "round trip")
+}
+
+func TestIsRequestIsResponse(t *testing.T) {
+	require.True(t, ntpRequest.IsRequest())
+	require.False(t, ntpRequest.IsResponse())
+	require.False(t, ntpResponse.IsRequest())
+	require.True(t, ntpResponse.IsResponse())
+
+	p := &Packet{}
+	p.SetMode(modeSymActive)
+	require.True(t, p.IsRequest())
+	p.SetMode(modeSymPassive)
+	require.True(t, p.IsResponse())
+	p.SetMode(modeBroadcast)
+	require.False(t, p.IsRequest())
+	require.False(t, p.IsResponse())
+}
+
+func TestSetTimes(t *testing.T) {
+	p := &Packet{Settings: ntpResponse.Settings}
+	p.SetReferenceTime(time.Date(2020, time.March, 26, 11, 10, 0, 0, time.UTC))
+	p.SetOriginTime(time.Date(2020, time.March, 26, 11, 24, 39, 632884075, time.UTC))
+	p.SetReceiveTime(time.Date(2020, time.March, 26, 11, 24, 39, 632921111, time.UTC))
+	p.SetTransmitTime(time.Date(2020, time.March, 26, 11, 24, 39, 633241953, time.UTC))
+
+	require.Equal(t, ntpResponse.RefTimeSec, p.RefTimeSec)
+	require.Equal(t, ntpResponse.RefTimeFrac, p.RefTimeFrac)
+	require.Equal(t, ntpResponse.OrigTimeSec, p.OrigTimeSec)
+	require.Equal(t, ntpResponse.OrigTimeFrac, p.OrigTimeFrac)
+	require.Equal(t, ntpResponse.RxTimeSec, p.RxTimeSec)
+	require.Equal(t, ntpResponse.RxTimeFrac, p.RxTimeFrac)
+	require.Equal(t, ntpResponse.TxTimeSec, p.TxTimeSec)
+	require.Equal(t, ntpResponse.TxTimeFrac, p.TxTimeFrac)
+}
+
+func TestGetTimes(t *testing.T) {
+	require.Equal(t, time.Date(2020, time.March, 26, 11, 24, 39, 633241953, time.UTC), ntpResponse.TransmitTime().UTC())
+	require.Equal(t, time.Date(2020, time.March, 26, 11, 24, 39, 632921111, time.UTC), ntpResponse.ReceiveTime().UTC())
+	require.Equal(t, time.Date(2020, time.March, 26, 11, 24, 39, 632884075, time.UTC), ntpResponse.OriginTime().UTC())
+	require.Equal(t, time.Date(2020, time.March, 26, 11, 10, 0, 0, time.UTC), ntpResponse.ReferenceTime().UTC())
+	require.Equal(t, ntpResponse.TxTimestamp().ToTime(), ntpResponse.TransmitTime())
+
+	// setters and getters are symmetric
+	now := time.Now()
+	p := &Packet{}
+	p.SetTransmitTime(now)
+	require.WithinDuration(t, now, p.TransmitTime(), time.Nanosecond)
+}
+
+func TestKissCode(t *testing.T) {
+	p := &Packet{Settings: 0x24, Stratum: 0, ReferenceID: binary.BigEndian.Uint32([]byte("RATE"))}
+	require.True(t, p.IsKissOfDeath())
+	require.Equal(t, "RATE", p.KissCode())
+}
+
+func TestKissCodeNotKoD(t *testing.T) {
+	require.False(t, ntpResponse.IsKissOfDeath())
+	require.Equal(t, "", ntpResponse.KissCode())
+}
+
+func TestIsUnsynchronized(t *testing.T) {
+	require.False(t, ntpResponse.IsUnsynchronized())
+
+	p := *ntpResponse
+	p.SetLeapIndicator(liAlarmCondition)
+	require.True(t, p.IsUnsynchronized())
+
+	p = *ntpResponse
+	p.Stratum = 0
+	require.True(t, p.IsUnsynchronized())
+
+	p = *ntpResponse
+	p.Stratum = 16
+	require.True(t, p.IsUnsynchronized())
+
+	p = *ntpResponse
+	p.SetRefTimestamp(NTPTimestamp{})
+	require.True(t, p.IsUnsynchronized())
+
+	p = *ntpResponse
+	p.SetLeapIndicator(liLastMinute61)
+	p.Stratum = 15
+	require.False(t, p.IsUnsynchronized())
+}
+
+func TestRefTimeAge(t *testing.T) {
+	// reference timestamp of ntpResponse is 2020-03-26 11:10:00 UTC
+	now := time.Date(2020, time.March, 26, 11, 24, 39, 500000000, time.UTC)
+	require.Equal(t, 14*time.Minute+39*time.Second+500*time.Millisecond, ntpResponse.RefTimeAge(now))
+
+	// reference time ahead of now
+	require.Equal(t, -time.Minute, ntpResponse.RefTimeAge(time.Date(2020, time.March, 26, 11, 9, 0, 0, time.UTC)))
+}
+
+func TestRefIDAddress(t *testing.T) {
+	require.Nil(t, ntpResponse.RefIDAddress())
+
+	p := *ntpResponse
+	p.Stratum = 2
+	require.Equal(t, net.ParseIP("70.66.32.32").To4(), p.RefIDAddress())
+	require.Equal(t, "70.66.32.32", p.RefIDAddress().String())
+
+	p.Stratum = 0
+	require.Nil(t, p.RefIDAddress())
+}
+
+func TestRefIDString(t *testing.T) {
+	require.Equal(t, "FB  ", ntpResponse.RefIDString())
+
+	p := &Packet{Stratum: 1, ReferenceID: binary.BigEndian.Uint32([]byte{'G', 'P', 'S', 0})}
+	require.Equal(t, "GPS", p.RefIDString())
+
+	p.Stratum = 2
+	require.Equal(t, "", p.RefIDString())
+}
+
+func TestRootDelayDuration(t *testing.T) {
+	require.Equal(t, time.Duration(0), ntpResponse.RootDelayDuration())
+	require.Equal(t, time.Second, ntpRequest.RootDelayDuration())
+
+	p := &Packet{RootDelay: 0x00018000}
+	require.Equal(t, 1500*time.Millisecond, p.RootDelayDuration())
+}
+
+func TestRootDispersionDuration(t *testing.T) {
+	// 10/65536 seconds
+	require.Equal(t, 152587*time.Nanosecond, ntpResponse.RootDispersionDuration())
+	require.Equal(t, time.Second, ntpRequest.RootDispersionDuration())
+}
+
+func TestRootDistance(t *testing.T) {
+	// rtt/2 + rootdispersion + 15PPM of rtt, precision of 2^-32 is less than 1ns
+	expected := 15*time.Millisecond + 152587*time.Nanosecond + 450*time.Nanosecond
+	require.Equal(t, expected, ntpResponse.RootDistance(roundTripDelay))
+
+	p := *ntpResponse
+	p.RootDelay = 65536
+	require.Equal(t, expected+500*time.Millisecond, p.RootDistance(roundTripDelay))
+}
+
+func TestRootDistanceNegativeRTT(t *testing.T) {
+	require.Equal(t, 152587*time.Nanosecond, ntpResponse.RootDistance(-10))
+}
+
+func TestPollInterval(t *testing.T) {
+	require.Equal(t, 8*time.Second, ntpResponse.PollInterval())
+
+	p := &Packet{Poll: -5}
+	require.Equal(t, time.Second, p.PollInterval())
+
+	p.Poll = 100
+	require.Equal(t, 131072*time.Second, p.PollInterval())
+}
+
+func TestPrecisionDuration(t *testing.T) {
+	// 2^-6 seconds is 15.625ms
+	require.Equal(t, 15625*time.Microsecond, ntpRequest.PrecisionDuration())
+	// 2^-32 seconds is about 0.23ns, below Duration resolution
+	require.Equal(t, time.Duration(0), ntpResponse.PrecisionDuration())
+
+	for _, tt := range []struct {
+		precision int8
+		expected  time.Duration
+	}{
+		{precision: -20, expected: 953 * time.Nanosecond},
+		{precision: -29, expected: time.Nanosecond},
+		{precision: -30, expected: 0},
+		{precision: -1, expected: 500 * time.Millisecond},
+		{precision: 0, expected: time.Second},
+		{precision: 1, expected: 2 * time.Second},
+		{precision: 10, expected: 1024 * time.Second},
+	} {
+		p := &Packet{Precision: tt.precision}
+		require.Equal(t, tt.expected, p.PrecisionDuration(), "precision %d", tt.precision)
+	}
+}
+
 func TestTime(t *testing.T) {
 	testtime := time.Unix(usec, unsec)
 	sec, frac := Time(testtime)
@@ -154,8 +543,89 @@ func TestUnix(t *testing.T) {
 	testtime := Unix(nsec, nfrac)
 
 	require.Equal(t, usec, testtime.Unix())
-	// +1ns is a rounding issue
-	require.Equal(t, unsec, int64(testtime.Nanosecond())+1)
+	require.Equal(t, unsec, int64(testtime.Nanosecond()))
+}
+
+func TestTimeUnixRoundTrip(t *testing.T) {
+	for _, nanos := range []int64{0, 1, 999_999_999, unsec, 10_000_000, 123_456_789} {
+		testtime := time.Unix(usec, nanos)
+		require.Equal(t, testtime, Unix(Time(testtime)))
+	}
+}
+
+func TestTimeEra(t *testing.T) {
+	now := time.Now()
+	sec, frac, era := TimeEra(now)
+	require.Equal(t, 0, era)
+	expectedSec, expectedFrac := Time(now)
+	require.Equal(t, expectedSec, sec)
+	require.Equal(t, expectedFrac, frac)
+
+	// seconds wrap in 2036, era tells them apart
+	future := time.Date(2040, time.March, 1, 12, 30, 15, 250000000, time.UTC)
+	sec, frac, era = TimeEra(future)
+	require.Equal(t, 1, era)
+	require.Equal(t, uint32(future.Sub(time.Date(2036, time.February, 7, 6, 28, 16, 0, time.UTC))/time.Second), sec)
+	require.Equal(t, future, UnixEra(sec, frac, era).UTC())
+
+	// Time silently drops the era
+	wrappedSec, wrappedFrac := Time(future)
+	require.Equal(t, sec, wrappedSec)
+	require.Equal(t, frac, wrappedFrac)
+	require.Equal(t, future.Add(-(1<<32)*time.Second), Unix(wrappedSec, wrappedFrac).UTC())
+
+	// last second of era 0
+	sec, _, era = TimeEra(time.Date(2036, time.February, 7, 6, 28, 15, 0, time.UTC))
+	require.Equal(t, 0, era)
+	require.Equal(t, uint32(1<<32-1), sec)
+}
+
+func TestUnixEra(t *testing.T) {
+	// Era 0 is the same as Unix
+	require.Equal(t, Unix(nsec, nfrac), UnixEra(nsec, nfrac, 0))
+
+	// Era 1 starts Feb-7 2036 06:28:16 UTC
+	eraStart := time.Date(2036, time.February, 7, 6, 28, 16, 0, time.UTC)
+	require.Equal(t, eraStart, UnixEra(0, 0, 1).UTC())
+	require.Equal(t, eraStart.Add(100*time.Second), UnixEra(100, 0, 1).UTC())
+	require.True(t, UnixEra(100, 0, 1).After(UnixEra(nsec, nfrac, 0)))
+}
+
+func TestNTPTimestamp(t *testing.T) {
+	ts := NTPTimestamp{Seconds: nsec, Fraction: nfrac}
+	require.Equal(t, time.Unix(usec, unsec), ts.ToTime())
+	require.False(t, ts.IsZero())
+
+	var ts2 NTPTimestamp
+	require.True(t, ts2.IsZero())
+	ts2.FromTime(time.Unix(usec, unsec))
+	require.Equal(t, ts, ts2)
+}
+
+func TestPacketTimestamps(t *testing.T) {
+	require.Equal(t, NTPTimestamp{Seconds: 3794209800, Fraction: 0}, ntpResponse.RefTimestamp())
+	require.Equal(t, NTPTimestamp{Seconds: 3794210679, Fraction: 2718216404}, ntpResponse.OrigTimestamp())
+	require.Equal(t, NTPTimestamp{Seconds: 3794210679, Fraction: 2718375472}, ntpResponse.RxTimestamp())
+	require.Equal(t, NTPTimestamp{Seconds: 3794210679, Fraction: 2719753478}, ntpResponse.TxTimestamp())
+
+	p := &Packet{
+		Settings:       ntpResponse.Settings,
+		Stratum:        ntpResponse.Stratum,
+		Poll:           ntpResponse.Poll,
+		Precision:      ntpResponse.Precision,
+		RootDelay:      ntpResponse.RootDelay,
+		RootDispersion: ntpResponse.RootDispersion,
+		ReferenceID:    ntpResponse.ReferenceID,
+	}
+	p.SetRefTimestamp(ntpResponse.RefTimestamp())
+	p.SetOrigTimestamp(ntpResponse.OrigTimestamp())
+	p.SetRxTimestamp(ntpResponse.RxTimestamp())
+	p.SetTxTimestamp(ntpResponse.TxTimestamp())
+	require.Equal(t, ntpResponse, p)
+
+	b, err := p.Bytes()
+	require.NoError(t, err)
+	require.Equal(t, ntpResponseBytes, b)
 }
 
 func TestRoundTripDelay(t *testing.T) {
@@ -245,12 +715,85 @@ func TestOffsetAsymmetricNetwork(t *testing.T) {
 	require.Equal(t, offset, actualOffset)
 }
 
+func TestOffsetWithAsymmetry(t *testing.T) {
+	// Assuming time on client is = time on server
+	// Asymetric network latency (one way delay in not the same in both directions)
+
+	originTime := time.Now()
+	// Network delay client -> server 10ms
+	serverReceiveTime := originTime.Add(forwardDelay)
+	// OS delay server 10us
+	serverTransmitTime := serverReceiveTime.Add(10 * time.Microsecond)
+	// Network delay client -> server 20ms
+	clientReceiveTime := serverTransmitTime.Add(returnDelay)
+
+	// no correction is the same as basic offset
+	actualOffset := OffsetWithAsymmetry(originTime, serverReceiveTime, serverTransmitTime, clientReceiveTime, 0)
+	require.Equal(t, offset, actualOffset)
+
+	// known asymmetry removes the bias completely
+	actualOffset = OffsetWithAsymmetry(originTime, serverReceiveTime, serverTransmitTime, clientReceiveTime, forwardDelay-returnDelay)
+	require.Equal(t, int64(0), actualOffset)
+}
+
+func TestOffsetWithAsymmetryClockDelta(t *testing.T) {
+	// Assuming time on server is 50ms ahead of client
+	clientServerTsDelta := 50 * time.Millisecond
+
+	originTime := time.Now()
+	serverReceiveTime := originTime.Add(forwardDelay).Add(clientServerTsDelta)
+	serverTransmitTime := serverReceiveTime.Add(10 * time.Microsecond)
+	clientReceiveTime := serverTransmitTime.Add(returnDelay).Add(-clientServerTsDelta)
+
+	actualOffset := OffsetWithAsymmetry(originTime, serverReceiveTime, serverTransmitTime, clientReceiveTime, forwardDelay-returnDelay)
+	require.Equal(t, clientServerTsDelta.Nanoseconds(), actualOffset)
+}
+
+func TestOffsetAndRoundTripDelayDuration(t *testing.T) {
+	originTime := time.Now()
+	// Network delay client -> server 10ms
+	serverReceiveTime := originTime.Add(forwardDelay)
+	// OS delay server 10us
+	serverTransmitTime := serverReceiveTime.Add(10 * time.Microsecond)
+	// Network delay client -> server 20ms
+	clientReceiveTime := serverTransmitTime.Add(returnDelay)
+
+	require.Equal(t, time.Duration(offset), OffsetDuration(originTime, serverReceiveTime, serverTransmitTime, clientReceiveTime))
+	require.Equal(t, time.Duration(roundTripDelay), RoundTripDelayDuration(originTime, serverReceiveTime, serverTransmitTime, clientReceiveTime))
+}
+
+func TestCorrectTimeDuration(t *testing.T) {
+	clientReceiveTime := time.Now()
+	require.Equal(t, CorrectTime(clientReceiveTime, offset), CorrectTimeDuration(clientReceiveTime, time.Duration(offset)))
+	require.Equal(t, clientReceiveTime.Add(-5*time.Millisecond), CorrectTimeDuration(clientReceiveTime, time.Duration(offset)))
+}
+
 func TestCorrectTime(t *testing.T) {
 	clientReceiveTime := time.Now()
 	currentRealTime := CorrectTime(clientReceiveTime, offset)
 	require.Equal(t, clientReceiveTime.Add(time.Duration(offset)), currentRealTime)
 }
 
+func TestCorrectTimeWithLeap(t *testing.T) {
+	clientReceiveTime := time.Now()
+	expected := CorrectTime(clientReceiveTime, offset)
+
+	tests := []struct {
+		li      uint8
+		pending bool
+	}{
+		{li: 0, pending: false},
+		{li: 1, pending: true},
+		{li: 2, pending: true},
+		{li: 3, pending: false},
+	}
+	for _, tt := range tests {
+		currentRealTime, pending := CorrectTimeWithLeap(clientReceiveTime, offset, tt.li)
+		require.Equal(t, expected, currentRealTime)
+		require.Equal(t, tt.pending, pending, "li %d", tt.li)
+	}
+}
+
 func TestReadNTPPacket(t *testing.T) {
 	// listen to incoming udp packets
 	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("localhost"), Port: 0})
@@ -268,6 +811,149 @@ func TestReadNTPPacket(t *testing.T) {
 	require.Equal(t, ntpRequest, request, "We should have the same request arriving on the server")
 	require.Equal(t, cconn.LocalAddr().String(), returnaddr.String())
 	require.NoError(t, err)
+
+	// short datagram is not padded with zeroes
+	_, err = cconn.Write(ntpRequestBytes[:3])
+	require.NoError(t, err)
+	_, _, err = ReadNTPPacket(conn)
+	require.ErrorIs(t, err, ErrShortPacket)
+}
+
+func TestReadNTPPacketStrict(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 0})
+	require.NoError(t, err)
+	defer conn.Close()
+
+	cconn, err := net.Dial("udp", conn.LocalAddr().String())
+	require.NoError(t, err)
+	defer cconn.Close()
+
+	// plain packet is accepted
+	_, err = cconn.Write(ntpRequestBytes)
+	require.NoError(t, err)
+	request, addr, err := ReadNTPPacketStrict(conn)
+	require.NoError(t, err)
+	require.Equal(t, ntpRequest, request)
+	require.Equal(t, cconn.LocalAddr().String(), addr.String())
+
+	// jumbo datagram is rejected
+	_, err = cconn.Write(make([]byte, 9000))
+	require.NoError(t, err)
+	request, addr, err = ReadNTPPacketStrict(conn)
+	require.ErrorIs(t, err, ErrOversizedPacket)
+	require.Nil(t, request)
+	require.Equal(t, cconn.LocalAddr().String(), addr.String())
+
+	// so is a single extra byte
+	_, err = cconn.Write(append(append([]byte{}, ntpRequestBytes...), 0))
+	require.NoError(t, err)
+	_, _, err = ReadNTPPacketStrict(conn)
+	require.ErrorIs(t, err, ErrOversizedPacket)
+
+	// short one is rejected too
+	_, err = cconn.Write(ntpRequestBytes[:10])
+	require.NoError(t, err)
+	_, _, err = ReadNTPPacketStrict(conn)
+	require.ErrorIs(t, err, ErrShortPacket)
+}
+
+func TestReadNTPPacketIPv6(t *testing.T) {
+	// listen to incoming udp6 packets
+	conn, err := net.ListenUDP("udp6", &net.UDPAddr{IP: net.IPv6loopback, Port: 0})
+	if err != nil {
+		t.Skipf("IPv6 is not available: %v", err)
+	}
+	defer conn.Close()
+
+	// Send a client request
+	cconn, err := net.Dial("udp6", conn.LocalAddr().String())
+	require.NoError(t, err)
+	defer cconn.Close()
+	_, err = cconn.Write(ntpRequestBytes)
+	require.NoError(t, err)
+
+	request, returnaddr, err := ReadNTPPacket(conn)
+	require.NoError(t, err)
+	require.Equal(t, ntpRequest, request, "We should have the same request arriving on the server")
+	require.Equal(t, cconn.LocalAddr(), returnaddr)
+
+	// reply must reach the client via returned address
+	_, err = conn.WriteTo(ntpResponseBytes, returnaddr)
+	require.NoError(t, err)
+	buf := make([]byte, PacketSizeBytes)
+	n, err := cconn.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, ntpResponseBytes, buf[:n])
+}
+
+func TestTimestampSourceString(t *testing.T) {
+	require.Equal(t, "software", SourceSoftware.String())
+	require.Equal(t, "hardware", SourceHardware.String())
+	require.Equal(t, "unknown(42)", TimestampSource(42).String())
+}
+
+func TestReadNTPPacketRaw(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 0})
+	require.NoError(t, err)
+	defer conn.Close()
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+	cconn, err := net.DialUDP("udp", nil, conn.LocalAddr().(*net.UDPAddr))
+	require.NoError(t, err)
+	defer cconn.Close()
+
+	// request with extension field and MAC
+	request := *ntpRequest
+	request.AppendExtension(ExtensionField{Type: 0x0104, Value: []byte("unique identifier")})
+	require.NoError(t, request.AppendMAC(1, []byte("secret"), MACAlgoSHA1))
+	sent, err := request.Bytes()
+	require.NoError(t, err)
+	_, err = cconn.Write(sent)
+	require.NoError(t, err)
+
+	p, raw, addr, err := ReadNTPPacketRaw(conn)
+	require.NoError(t, err)
+	require.Equal(t, sent, raw)
+	require.Equal(t, cconn.LocalAddr().String(), addr.String())
+	require.Equal(t, request.TxTimestamp(), p.TxTimestamp())
+	ok, err := p.VerifyMAC([]byte("secret"))
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	// malformed datagram is still returned as is
+	_, err = cconn.Write([]byte{1, 2, 3})
+	require.NoError(t, err)
+	p, raw, _, err = ReadNTPPacketRaw(conn)
+	require.ErrorIs(t, err, ErrShortPacket)
+	require.Nil(t, p)
+	require.Equal(t, []byte{1, 2, 3}, raw)
+}
+
+func TestWritePacket(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 0})
+	require.NoError(t, err)
+	defer conn.Close()
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+
+	// connected socket
+	cconn, err := net.DialUDP("udp", nil, conn.LocalAddr().(*net.UDPAddr))
+	require.NoError(t, err)
+	defer cconn.Close()
+	require.NoError(t, WritePacket(cconn, ntpResponse, nil))
+	p, addr, err := ReadNTPPacket(conn)
+	require.NoError(t, err)
+	require.Equal(t, ntpResponse, p)
+
+	// reply from unconnected socket, with extension field bigger than the pooled buffer
+	response := *ntpResponse
+	response.AppendExtension(ExtensionField{Type: 0x0104, Value: make([]byte, 32)})
+	require.NoError(t, WritePacket(conn, &response, addr))
+	buf := make([]byte, 1024)
+	require.NoError(t, cconn.SetReadDeadline(time.Now().Add(time.Second)))
+	n, err := cconn.Read(buf)
+	require.NoError(t, err)
+	expected, err := response.Bytes()
+	require.NoError(t, err)
+	require.Equal(t, expected, buf[:n])
 }
 
 func Benchmark_PacketToBytesConversion(b *testing.B) {
@@ -276,12 +962,55 @@ func Benchmark_PacketToBytesConversion(b *testing.B) {
 	}
 }
 
+func Benchmark_PacketMarshalTo(b *testing.B) {
+	buf := make([]byte, PacketSizeBytes)
+	for i := 0; i < b.N; i++ {
+		_, _ = ntpResponse.MarshalTo(buf)
+	}
+}
+
+func Benchmark_WriteBytes(b *testing.B) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("localhost"), Port: 0})
+	require.NoError(b, err)
+	defer conn.Close()
+	cconn, err := net.DialUDP("udp", nil, conn.LocalAddr().(*net.UDPAddr))
+	require.NoError(b, err)
+	defer cconn.Close()
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		buf, _ := ntpResponse.Bytes()
+		_, _ = cconn.Write(buf)
+	}
+}
+
+func Benchmark_WritePacket(b *testing.B) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("localhost"), Port: 0})
+	require.NoError(b, err)
+	defer conn.Close()
+	cconn, err := net.DialUDP("udp", nil, conn.LocalAddr().(*net.UDPAddr))
+	require.NoError(b, err)
+	defer cconn.Close()
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_ = WritePacket(cconn, ntpResponse, nil)
+	}
+}
+
 func Benchmark_BytesToPacketConversion(b *testing.B) {
 	for i := 0; i < b.N; i++ {
 		_, _ = BytesToPacket(ntpResponseBytes)
 	}
 }
 
+func Benchmark_UnmarshalBinary(b *testing.B) {
+	packet := &Packet{}
+	for i := 0; i < b.N; i++ {
+		_ = packet.UnmarshalBinary(ntpResponseBytes)
+	}
+}
+
 /*
 Benchmark_ServerWithoutKernelTimestamps is a benchmark to determine speed of
 reading NTP packets without kernel timestamps
@@ -393,11 +1122,53 @@ func FuzzBytesToPacket(f *testing.F) {
 		f.Add(seed)
 	}
 	f.Fuzz(func(t *testing.T, b []byte) {
-		packet, err := BytesToPacket(b)
-		if err == nil {
-			bb, err := packet.Bytes()
-			require.NoError(t, err)
-			require.Equal(t, b[:len(bb)], bb)
+		bb, err := RoundTrip(b)
+		if err != nil {
+			return
 		}
+		require.Equal(t, b[:PacketSizeBytes], bb[:PacketSizeBytes])
+		// short last extension field gets padded, after that encoding is stable
+		again, err := RoundTrip(bb)
+		require.NoError(t, err)
+		require.Equal(t, bb, again)
 	})
 }
+
+func TestRoundTrip(t *testing.T) {
+	b, err := RoundTrip(ntpResponseBytes)
+	require.NoError(t, err)
+	require.Equal(t, ntpResponseBytes, b)
+
+	// unknown trailing bytes are dropped
+	b, err = RoundTrip(append(append([]byte{}, ntpRequestBytes...), 1, 2, 3))
+	require.NoError(t, err)
+	require.Equal(t, ntpRequestBytes, b)
+
+	_, err = RoundTrip([]byte{9})
+	require.ErrorIs(t, err, ErrShortPacket)
+}
+
+func TestPacketString(t *testing.T) {
+	expected := "LI: 0, VN: 4, Mode: 4 (server), Stratum: 1, Poll: 3, Precision: -32, RootDelay: 0s, RootDispersion: 152.587µs, " +
+		"RefID: FB, Ref: 2020-03-26T11:10:00Z, Orig: 2020-03-26T11:24:39.632884075Z, Rx: 2020-03-26T11:24:39.632921111Z, Tx: 2020-03-26T11:24:39.633241953Z"
+	require.Equal(t, expected, ntpResponse.String())
+
+	p := *ntpResponse
+	p.Stratum = 2
+	p.ReferenceID = 0x0a000001
+	require.Contains(t, p.String(), "Stratum: 2, ")
+	require.Contains(t, p.String(), "RefID: 10.0.0.1, ")
+
+	p.Stratum = 0
+	p.ReferenceID = 0x52415445
+	require.Contains(t, p.String(), "RefID: RATE, ")
+}
+
+func TestMatchesOrigin(t *testing.T) {
+	sent := time.Unix(usec, unsec)
+	p := &Packet{}
+	p.OrigTimeSec, p.OrigTimeFrac = Time(sent)
+	require.True(t, p.MatchesOrigin(sent))
+	require.False(t, p.MatchesOrigin(sent.Add(time.Microsecond)))
+	require.False(t, (&Packet{}).MatchesOrigin(sent))
+}
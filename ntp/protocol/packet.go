@@ -18,8 +18,16 @@ package protocol
 
 import (
 	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
 	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
 	"net"
+	"strings"
+	"sync"
+	"time"
 )
 
 // PacketSizeBytes sets the size of NTP packet
@@ -28,7 +36,10 @@ const PacketSizeBytes = 48
 // ControlHeaderSizeBytes is a buffer to read packet header with Kernel timestamps
 const ControlHeaderSizeBytes = 32
 
-// Packet is an NTPv4 packet
+// Packet is an NTPv4 packet: the 48 bytes header plus optional extension fields and MAC.
+// Since extension fields and MAC were added Packet is not a fixed-size struct anymore:
+// binary.Read/binary.Write fail on it at runtime and it can't be compared with ==.
+// Use Bytes/MarshalTo and UnmarshalBinary/BytesToPacket to encode and decode it
 /*
 http://seriot.ch/ntp.php
 https://tools.ietf.org/html/rfc958
@@ -88,16 +99,92 @@ type Packet struct {
 	RxTimeFrac     uint32 // receive time frac
 	TxTimeSec      uint32 // transmit time sec
 	TxTimeFrac     uint32 // transmit time frac
+
+	extensions []ExtensionField // optional extension fields following the header
+	mac        []byte           // optional key ID and message digest following extension fields
 }
 
 const (
 	liNoWarning      = 0
+	liLastMinute61   = 1
+	liLastMinute59   = 2
 	liAlarmCondition = 3
 	vnFirst          = 1
 	vnLast           = 4
+	modeReserved     = 0
+	modeSymActive    = 1
+	modeSymPassive   = 2
 	modeClient       = 3
+	modeServer       = 4
+	modeBroadcast    = 5
+)
+
+// maxStratum is the highest valid stratum, 16 means unsynchronized
+const maxStratum = 16
+
+// minRequestVersion is the oldest version ValidateRequest accepts
+const minRequestVersion = 3
+
+// Errors returned by Validate and ValidateRequest
+var (
+	ErrInvalidStratum   = errors.New("invalid stratum")
+	ErrInvalidMode      = errors.New("invalid mode")
+	ErrInvalidVersion   = errors.New("invalid version")
+	ErrZeroTransmitTime = errors.New("transmit timestamp is zero")
 )
 
+// ErrTimestampOrder is returned by ValidTimestampOrder when response timestamps violate causality
+var ErrTimestampOrder = errors.New("invalid timestamp order")
+
+// ErrOversizedPacket is returned when datagram is bigger than plain NTP packet
+var ErrOversizedPacket = errors.New("packet is too big")
+
+// ErrShortPacket is returned when there is not enough data to decode Packet
+var ErrShortPacket = errors.New("packet is too short")
+
+// LeapIndicator returns the LI field of Settings (top 2 bits)
+func (p *Packet) LeapIndicator() uint8 {
+	return p.Settings >> 6
+}
+
+// Version returns the VN field of Settings (middle 3 bits)
+func (p *Packet) Version() uint8 {
+	return (p.Settings >> 3) & 0x7
+}
+
+// Mode returns the Mode field of Settings (bottom 3 bits)
+func (p *Packet) Mode() uint8 {
+	return p.Settings & 0x7
+}
+
+// IsRequest returns true if packet expects a reply: client (3) or symmetric active (1) mode.
+// Broadcast clients don't send packets, so there is no mode for them
+func (p *Packet) IsRequest() bool {
+	m := p.Mode()
+	return m == modeClient || m == modeSymActive
+}
+
+// IsResponse returns true if packet is a reply: server (4) or symmetric passive (2) mode
+func (p *Packet) IsResponse() bool {
+	m := p.Mode()
+	return m == modeServer || m == modeSymPassive
+}
+
+// SetLeapIndicator sets the LI field of Settings leaving VN and Mode untouched
+func (p *Packet) SetLeapIndicator(li uint8) {
+	p.Settings = (p.Settings & 0x3f) | (li&0x3)<<6
+}
+
+// SetVersion sets the VN field of Settings leaving LI and Mode untouched
+func (p *Packet) SetVersion(v uint8) {
+	p.Settings = (p.Settings & 0xc7) | (v&0x7)<<3
+}
+
+// SetMode sets the Mode field of Settings leaving LI and VN untouched
+func (p *Packet) SetMode(m uint8) {
+	p.Settings = (p.Settings & 0xf8) | m&0x7
+}
+
 // ValidSettingsFormat verifies that LI | VN  |Mode fields are set correctly
 // check the first byte,include:
 // LN:must be 0 or 3
@@ -106,10 +193,9 @@ const (
 func (p *Packet) ValidSettingsFormat() bool {
 	settings := p.Settings
 	var l = settings >> 6
-	var v = (settings << 2) >> 5
 	var m = (settings << 5) >> 5
 	if (l == liNoWarning) || (l == liAlarmCondition) {
-		if (v >= vnFirst) && (v <= vnLast) {
+		if p.validVersion() {
 			if m == modeClient {
 				return true
 			}
@@ -118,17 +204,414 @@ func (p *Packet) ValidSettingsFormat() bool {
 	return false
 }
 
+// validVersion verifies VN is 1,2,3 or 4
+func (p *Packet) validVersion() bool {
+	v := p.Version()
+	return (v >= vnFirst) && (v <= vnLast)
+}
+
+// Validate verifies the packet received from the server makes sense:
+// Stratum: must be 0-16
+// VN: must be 1,2,3 or 4
+// Mode: must not be reserved
+// Transmit timestamp: must not be zero
+// ValidSettingsFormat can't be used here: it only accepts client mode with LI 0 or 3,
+// so it would reject every server response and any leap second warning
+func (p *Packet) Validate() error {
+	if p.Stratum > maxStratum {
+		return fmt.Errorf("%w: %d", ErrInvalidStratum, p.Stratum)
+	}
+	if !p.validVersion() {
+		return fmt.Errorf("%w: %d", ErrInvalidVersion, p.Version())
+	}
+	if p.Mode() == modeReserved {
+		return fmt.Errorf("%w: %d", ErrInvalidMode, p.Mode())
+	}
+	if p.TxTimestamp().IsZero() {
+		return ErrZeroTransmitTime
+	}
+	return nil
+}
+
+// ValidateRequest verifies the packet is a well-formed request before sending it to the server:
+// Mode: must be client (3) or symmetric active (1)
+// VN: must be 3, 4 or 5
+// Transmit timestamp: must not be zero
+func (p *Packet) ValidateRequest() error {
+	if !p.IsRequest() {
+		return fmt.Errorf("%w: %d", ErrInvalidMode, p.Mode())
+	}
+	if v := p.Version(); v < minRequestVersion || v > vnV5 {
+		return fmt.Errorf("%w: %d", ErrInvalidVersion, v)
+	}
+	if p.TxTimestamp().IsZero() {
+		return ErrZeroTransmitTime
+	}
+	return nil
+}
+
+// ValidTimestampOrder verifies timestamps of the response make sense for the request sent at t1 (T1)
+// and the response received at t4 (T4): origin timestamp must match T1, server receive time (T2)
+// must not be after server transmit time (T3) and time spent on the server can't exceed T4 - T1
+func (p *Packet) ValidTimestampOrder(t1, t4 time.Time) error {
+	if !p.MatchesOrigin(t1) {
+		return ErrOriginMismatch
+	}
+	t2 := p.RxTimestamp().ToTime()
+	t3 := p.TxTimestamp().ToTime()
+	if t2.After(t3) {
+		return fmt.Errorf("%w: receive time %v is after transmit time %v", ErrTimestampOrder, t2, t3)
+	}
+	if t4.Before(t1) {
+		return fmt.Errorf("%w: response received at %v before request was sent at %v", ErrTimestampOrder, t4, t1)
+	}
+	if t3.Sub(t2) > t4.Sub(t1) {
+		return fmt.Errorf("%w: server processing time %v exceeds round trip time %v", ErrTimestampOrder, t3.Sub(t2), t4.Sub(t1))
+	}
+	return nil
+}
+
+// refIDBytes returns ReferenceID as 4 bytes in network order
+func (p *Packet) refIDBytes() []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, p.ReferenceID)
+	return b
+}
+
+// KissCode returns ASCII kiss code (like RATE, DENY or RSTR)
+// if packet is a Kiss-o'-Death packet and "" otherwise
+func (p *Packet) KissCode() string {
+	if !p.IsKissOfDeath() {
+		return ""
+	}
+	return string(p.refIDBytes())
+}
+
+// IsKissOfDeath returns true if packet is a Kiss-o'-Death packet (stratum 0)
+func (p *Packet) IsKissOfDeath() bool {
+	return p.Stratum == 0
+}
+
+// IsUnsynchronized returns true if the server is not synchronized and must not be trusted:
+// leap indicator is alarm condition (3), stratum is 0 (unspecified or Kiss-o'-Death) or 16,
+// or the reference timestamp is zero
+func (p *Packet) IsUnsynchronized() bool {
+	return p.LeapIndicator() == liAlarmCondition ||
+		p.Stratum == 0 || p.Stratum >= maxStratum ||
+		p.RefTimestamp().IsZero()
+}
+
+// RefIDAddress returns IPv4 address of the upstream server for secondary servers (stratum >= 2)
+// and nil otherwise
+func (p *Packet) RefIDAddress() net.IP {
+	if p.Stratum < 2 {
+		return nil
+	}
+	return net.IP(p.refIDBytes())
+}
+
+// RefIDString returns ASCII name of the reference clock (like GPS, PPS or PTP)
+// for primary servers (stratum 1) and "" otherwise
+func (p *Packet) RefIDString() string {
+	if p.Stratum != 1 {
+		return ""
+	}
+	return string(bytes.TrimRight(p.refIDBytes(), "\x00"))
+}
+
+// refIDText returns ReferenceID decoded according to the stratum
+func (p *Packet) refIDText() string {
+	switch {
+	case p.IsKissOfDeath():
+		return p.KissCode()
+	case p.Stratum == 1:
+		return strings.TrimSpace(p.RefIDString())
+	default:
+		return p.RefIDAddress().String()
+	}
+}
+
+// modeNames maps association modes to their names as per RFC 5905
+var modeNames = [...]string{"reserved", "symmetric active", "symmetric passive", "client", "server", "broadcast", "control", "private"}
+
+// String returns human-readable representation of the Packet
+func (p *Packet) String() string {
+	return fmt.Sprintf(
+		"LI: %d, VN: %d, Mode: %d (%s), Stratum: %d, Poll: %d, Precision: %d, RootDelay: %v, RootDispersion: %v, RefID: %s, Ref: %s, Orig: %s, Rx: %s, Tx: %s",
+		p.LeapIndicator(), p.Version(), p.Mode(), modeNames[p.Mode()],
+		p.Stratum, p.Poll, p.Precision,
+		p.RootDelayDuration(), p.RootDispersionDuration(),
+		p.refIDText(),
+		p.RefTimestamp().ToTime().UTC().Format(time.RFC3339Nano),
+		p.OrigTimestamp().ToTime().UTC().Format(time.RFC3339Nano),
+		p.RxTimestamp().ToTime().UTC().Format(time.RFC3339Nano),
+		p.TxTimestamp().ToTime().UTC().Format(time.RFC3339Nano),
+	)
+}
+
+// RefTimestamp returns reference timestamp as NTPTimestamp
+func (p *Packet) RefTimestamp() NTPTimestamp {
+	return NTPTimestamp{Seconds: p.RefTimeSec, Fraction: p.RefTimeFrac}
+}
+
+// SetRefTimestamp sets reference timestamp from NTPTimestamp
+func (p *Packet) SetRefTimestamp(t NTPTimestamp) {
+	p.RefTimeSec, p.RefTimeFrac = t.Seconds, t.Fraction
+}
+
+// OrigTimestamp returns origin timestamp as NTPTimestamp
+func (p *Packet) OrigTimestamp() NTPTimestamp {
+	return NTPTimestamp{Seconds: p.OrigTimeSec, Fraction: p.OrigTimeFrac}
+}
+
+// SetOrigTimestamp sets origin timestamp from NTPTimestamp
+func (p *Packet) SetOrigTimestamp(t NTPTimestamp) {
+	p.OrigTimeSec, p.OrigTimeFrac = t.Seconds, t.Fraction
+}
+
+// RxTimestamp returns receive timestamp as NTPTimestamp
+func (p *Packet) RxTimestamp() NTPTimestamp {
+	return NTPTimestamp{Seconds: p.RxTimeSec, Fraction: p.RxTimeFrac}
+}
+
+// SetRxTimestamp sets receive timestamp from NTPTimestamp
+func (p *Packet) SetRxTimestamp(t NTPTimestamp) {
+	p.RxTimeSec, p.RxTimeFrac = t.Seconds, t.Fraction
+}
+
+// TxTimestamp returns transmit timestamp as NTPTimestamp
+func (p *Packet) TxTimestamp() NTPTimestamp {
+	return NTPTimestamp{Seconds: p.TxTimeSec, Fraction: p.TxTimeFrac}
+}
+
+// SetTxTimestamp sets transmit timestamp from NTPTimestamp
+func (p *Packet) SetTxTimestamp(t NTPTimestamp) {
+	p.TxTimeSec, p.TxTimeFrac = t.Seconds, t.Fraction
+}
+
+// ReferenceTime returns reference timestamp as time.Time
+func (p *Packet) ReferenceTime() time.Time {
+	return Unix(p.RefTimeSec, p.RefTimeFrac)
+}
+
+// OriginTime returns origin timestamp as time.Time
+func (p *Packet) OriginTime() time.Time {
+	return Unix(p.OrigTimeSec, p.OrigTimeFrac)
+}
+
+// ReceiveTime returns receive timestamp as time.Time
+func (p *Packet) ReceiveTime() time.Time {
+	return Unix(p.RxTimeSec, p.RxTimeFrac)
+}
+
+// TransmitTime returns transmit timestamp as time.Time
+func (p *Packet) TransmitTime() time.Time {
+	return Unix(p.TxTimeSec, p.TxTimeFrac)
+}
+
+// SetReferenceTime sets reference timestamp from time.Time
+func (p *Packet) SetReferenceTime(t time.Time) {
+	p.RefTimeSec, p.RefTimeFrac = Time(t)
+}
+
+// SetOriginTime sets origin timestamp from time.Time
+func (p *Packet) SetOriginTime(t time.Time) {
+	p.OrigTimeSec, p.OrigTimeFrac = Time(t)
+}
+
+// SetReceiveTime sets receive timestamp from time.Time
+func (p *Packet) SetReceiveTime(t time.Time) {
+	p.RxTimeSec, p.RxTimeFrac = Time(t)
+}
+
+// SetTransmitTime sets transmit timestamp from time.Time
+func (p *Packet) SetTransmitTime(t time.Time) {
+	p.TxTimeSec, p.TxTimeFrac = Time(t)
+}
+
+// MatchesOrigin returns true if origin timestamp of the response equals transmit timestamp sent in the request
+func (p *Packet) MatchesOrigin(sent time.Time) bool {
+	var ts NTPTimestamp
+	ts.FromTime(sent)
+	return p.MatchesOriginTimestamp(ts)
+}
+
+// MatchesOriginTimestamp returns true if origin timestamp of the response equals sent exactly,
+// which is how random transmit timestamps (nonces) are verified
+func (p *Packet) MatchesOriginTimestamp(sent NTPTimestamp) bool {
+	return p.OrigTimestamp() == sent
+}
+
+// RefTimeAge returns how long before now the server clock was last synchronized (now - reference timestamp)
+func (p *Packet) RefTimeAge(now time.Time) time.Duration {
+	return now.Sub(p.RefTimestamp().ToTime())
+}
+
+// shortToDuration converts NTP short format (16.16 seconds) into time.Duration
+func shortToDuration(v uint32) time.Duration {
+	return time.Duration((uint64(v) * uint64(time.Second)) >> 16)
+}
+
+// RootDelayDuration returns RootDelay as time.Duration
+func (p *Packet) RootDelayDuration() time.Duration {
+	return shortToDuration(p.RootDelay)
+}
+
+// RootDispersionDuration returns RootDispersion as time.Duration
+func (p *Packet) RootDispersionDuration() time.Duration {
+	return shortToDuration(p.RootDispersion)
+}
+
+// phi is the frequency tolerance (15 PPM) as per RFC 5905
+const phi = 15e-6
+
+// RootDistance returns the synchronization distance (maximum error) for the sample
+// with round trip delay rtt in nanoseconds, as per RFC 5905:
+// λ = (rootdelay + delay)/2 + rootdispersion + dispersion
+// where the sample dispersion is server precision plus frequency tolerance accumulated over rtt
+func (p *Packet) RootDistance(rtt int64) time.Duration {
+	delay := time.Duration(rtt)
+	if delay < 0 {
+		delay = 0
+	}
+	dispersion := p.PrecisionDuration() + time.Duration(phi*float64(delay))
+
+	return (p.RootDelayDuration()+delay)/2 + p.RootDispersionDuration() + dispersion
+}
+
+// maxPoll is the maximum poll exponent as per RFC 5905 (36 hours)
+const maxPoll = 17
+
+// PollInterval returns Poll as time.Duration.
+// Negative values are treated as 1 second and values over maxPoll as 2^maxPoll seconds
+func (p *Packet) PollInterval() time.Duration {
+	poll := p.Poll
+	if poll < 0 {
+		poll = 0
+	}
+	if poll > maxPoll {
+		poll = maxPoll
+	}
+	return time.Duration(1<<poll) * time.Second
+}
+
+// PrecisionDuration returns Precision as time.Duration.
+// Precision finer than a nanosecond (below -29) is truncated to 0
+func (p *Packet) PrecisionDuration() time.Duration {
+	return time.Duration(math.Pow(2, float64(p.Precision)) * float64(time.Second))
+}
+
+// Clone returns a deep copy of the Packet. Extension fields and MAC are copied too,
+// so the clone can be kept around after the original is reused
+func (p *Packet) Clone() *Packet {
+	c := *p
+	if p.extensions != nil {
+		c.extensions = make([]ExtensionField, len(p.extensions))
+		for i, ef := range p.extensions {
+			c.extensions[i] = ef
+			if ef.Value != nil {
+				c.extensions[i].Value = append([]byte(nil), ef.Value...)
+			}
+		}
+	}
+	if p.mac != nil {
+		c.mac = append([]byte(nil), p.mac...)
+	}
+	return &c
+}
+
 // Bytes converts Packet to []bytes
 func (p *Packet) Bytes() ([]byte, error) {
-	var bytes bytes.Buffer
-	err := binary.Write(&bytes, binary.BigEndian, p)
-	return bytes.Bytes(), err
+	b := make([]byte, p.size())
+	n, err := p.MarshalTo(b)
+	return b[:n], err
 }
 
-// UnmarshalBinary fills the Packet from []bytes
+// size returns the length of the Packet on the wire
+func (p *Packet) size() int {
+	return PacketSizeBytes + p.extensionsSize(len(p.mac) > 0) + len(p.mac)
+}
+
+// MarshalTo writes Packet into provided buffer b and returns number of bytes written
+func (p *Packet) MarshalTo(b []byte) (int, error) {
+	if len(b) < p.size() {
+		return 0, fmt.Errorf("not enough buffer to write Packet: %d bytes, expected %d", len(b), p.size())
+	}
+	n, err := p.marshalUnsignedTo(b, len(p.mac) > 0)
+	if err != nil {
+		return 0, err
+	}
+	n += copy(b[n:], p.mac)
+	return n, nil
+}
+
+// marshalUnsignedTo writes header and extension fields into b, laid out as if MAC follows or not.
+// b must be big enough
+func (p *Packet) marshalUnsignedTo(b []byte, withMAC bool) (int, error) {
+	b[0] = p.Settings
+	b[1] = p.Stratum
+	b[2] = byte(p.Poll)
+	b[3] = byte(p.Precision)
+	binary.BigEndian.PutUint32(b[4:], p.RootDelay)
+	binary.BigEndian.PutUint32(b[8:], p.RootDispersion)
+	binary.BigEndian.PutUint32(b[12:], p.ReferenceID)
+	binary.BigEndian.PutUint32(b[16:], p.RefTimeSec)
+	binary.BigEndian.PutUint32(b[20:], p.RefTimeFrac)
+	binary.BigEndian.PutUint32(b[24:], p.OrigTimeSec)
+	binary.BigEndian.PutUint32(b[28:], p.OrigTimeFrac)
+	binary.BigEndian.PutUint32(b[32:], p.RxTimeSec)
+	binary.BigEndian.PutUint32(b[36:], p.RxTimeFrac)
+	binary.BigEndian.PutUint32(b[40:], p.TxTimeSec)
+	binary.BigEndian.PutUint32(b[44:], p.TxTimeFrac)
+
+	n := PacketSizeBytes
+	for i := range p.extensions {
+		l, err := p.extensions[i].marshalTo(b[n:], p.extensionSize(i, withMAC))
+		if err != nil {
+			return 0, err
+		}
+		n += l
+	}
+	return n, nil
+}
+
+// UnmarshalBinary fills the Packet from []bytes, including extension fields and MAC if present
 func (p *Packet) UnmarshalBinary(b []byte) error {
-	reader := bytes.NewReader(b)
-	return binary.Read(reader, binary.BigEndian, p)
+	if len(b) < PacketSizeBytes {
+		return fmt.Errorf("%w: got %d bytes, expected %d", ErrShortPacket, len(b), PacketSizeBytes)
+	}
+	p.Settings = b[0]
+	p.Stratum = b[1]
+	p.Poll = int8(b[2])
+	p.Precision = int8(b[3])
+	p.RootDelay = binary.BigEndian.Uint32(b[4:])
+	p.RootDispersion = binary.BigEndian.Uint32(b[8:])
+	p.ReferenceID = binary.BigEndian.Uint32(b[12:])
+	p.RefTimeSec = binary.BigEndian.Uint32(b[16:])
+	p.RefTimeFrac = binary.BigEndian.Uint32(b[20:])
+	p.OrigTimeSec = binary.BigEndian.Uint32(b[24:])
+	p.OrigTimeFrac = binary.BigEndian.Uint32(b[28:])
+	p.RxTimeSec = binary.BigEndian.Uint32(b[32:])
+	p.RxTimeFrac = binary.BigEndian.Uint32(b[36:])
+	p.TxTimeSec = binary.BigEndian.Uint32(b[40:])
+	p.TxTimeFrac = binary.BigEndian.Uint32(b[44:])
+
+	p.extensions = nil
+	p.mac = nil
+	if len(b) == PacketSizeBytes {
+		return nil
+	}
+	extensions, rest, err := parseExtensions(b[PacketSizeBytes:])
+	if err != nil {
+		return err
+	}
+	p.extensions = extensions
+	switch len(rest) {
+	case cryptoNAKSizeBytes, keyIDSizeBytes + md5.Size, keyIDSizeBytes + sha1.Size:
+		p.mac = make([]byte, len(rest))
+		copy(p.mac, rest)
+	}
+	return nil
 }
 
 // BytesToPacket converts []bytes to Packet
@@ -137,14 +620,132 @@ func BytesToPacket(ntpPacketBytes []byte) (*Packet, error) {
 	return packet, packet.UnmarshalBinary(ntpPacketBytes)
 }
 
-// ReadNTPPacket reads incoming NTP packet
+// BytesToPacketWithExtensions converts []bytes to Packet and returns
+// everything after the header (extension fields and MAC, if any) as a separate slice
+func BytesToPacketWithExtensions(ntpPacketBytes []byte) (*Packet, []byte, error) {
+	packet, err := BytesToPacket(ntpPacketBytes)
+	if err != nil {
+		return packet, nil, err
+	}
+	return packet, ntpPacketBytes[PacketSizeBytes:], nil
+}
+
+// RoundTrip decodes b as Packet and encodes it back. For valid input the result equals the start of b:
+// bytes which are neither header, extension fields nor MAC are dropped, and the last extension field
+// is padded to 28 bytes if there is no MAC
+func RoundTrip(b []byte) ([]byte, error) {
+	packet, err := BytesToPacket(b)
+	if err != nil {
+		return nil, err
+	}
+	return packet.Bytes()
+}
+
+// ReadNTPPacket reads incoming NTP packet.
+// Datagrams shorter than PacketSizeBytes are rejected with ErrShortPacket
 func ReadNTPPacket(conn *net.UDPConn) (ntp *Packet, remAddr net.Addr, err error) {
 	buf := make([]byte, PacketSizeBytes)
-	_, remAddr, err = conn.ReadFromUDP(buf)
+	n, remAddr, err := conn.ReadFromUDP(buf)
 	if err != nil {
 		return nil, nil, err
 	}
-	ntp, err = BytesToPacket(buf)
+	ntp, err = BytesToPacket(buf[:n])
 
 	return ntp, remAddr, err
 }
+
+// writeBufPool holds buffers reused by WritePacket
+var writeBufPool = sync.Pool{
+	New: func() interface{} {
+		b := make([]byte, PacketSizeBytes)
+		return &b
+	},
+}
+
+// WritePacket marshals p into a reused buffer and sends it to addr in a single call.
+// Pass nil addr to write into connected conn
+func WritePacket(conn *net.UDPConn, p *Packet, addr net.Addr) error {
+	bp := writeBufPool.Get().(*[]byte)
+	defer writeBufPool.Put(bp)
+	if size := p.size(); cap(*bp) < size {
+		*bp = make([]byte, size)
+	}
+	b := (*bp)[:cap(*bp)]
+	n, err := p.MarshalTo(b)
+	if err != nil {
+		return err
+	}
+	if addr == nil {
+		_, err = conn.Write(b[:n])
+	} else {
+		_, err = conn.WriteTo(b[:n], addr)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to send packet: %w", err)
+	}
+	return nil
+}
+
+// ReadNTPPacketStrict reads incoming NTP packet and rejects datagrams which don't fit into PacketSizeBytes
+// with ErrOversizedPacket, without parsing them
+func ReadNTPPacketStrict(conn *net.UDPConn) (*Packet, net.Addr, error) {
+	// one extra byte tells if datagram was truncated
+	buf := make([]byte, PacketSizeBytes+1)
+	n, addr, err := conn.ReadFromUDP(buf)
+	if err != nil {
+		return nil, nil, err
+	}
+	if n > PacketSizeBytes {
+		return nil, addr, ErrOversizedPacket
+	}
+	ntp, err := BytesToPacket(buf[:n])
+	if err != nil {
+		return nil, addr, err
+	}
+	return ntp, addr, nil
+}
+
+// ReadNTPPacketRaw reads incoming NTP packet and also returns the datagram exactly as received,
+// including extension fields and any trailing bytes, for MAC verification or logging.
+// Raw bytes are returned even if the packet can't be parsed
+func ReadNTPPacketRaw(conn *net.UDPConn) (*Packet, []byte, net.Addr, error) {
+	buf := make([]byte, requestBufferSizeBytes)
+	n, addr, err := conn.ReadFromUDP(buf)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	raw := buf[:n]
+	ntp, err := BytesToPacket(raw)
+	if err != nil {
+		return nil, raw, addr, err
+	}
+	return ntp, raw, addr, nil
+}
+
+// TimestampSource tells whether kernel timestamp was taken by the NIC or by the network stack
+type TimestampSource int
+
+// Supported timestamp sources
+const (
+	SourceSoftware TimestampSource = iota
+	SourceHardware
+)
+
+// String returns human-readable timestamp source
+func (s TimestampSource) String() string {
+	switch s {
+	case SourceSoftware:
+		return "software"
+	case SourceHardware:
+		return "hardware"
+	}
+	return fmt.Sprintf("unknown(%d)", int(s))
+}
+
+// timestampSource converts hardware flag reported by the timestamp package into TimestampSource
+func timestampSource(hw bool) TimestampSource {
+	if hw {
+		return SourceHardware
+	}
+	return SourceSoftware
+}
@@ -0,0 +1,248 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package protocol
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"time"
+	"unsafe"
+
+	"github.com/facebook/time/timestamp"
+	"golang.org/x/sys/unix"
+)
+
+// pollTimeoutMs is how often waitReadable checks if the socket was closed while waiting for packets
+const pollTimeoutMs = 100
+
+// errSocketClosed is returned by waitReadable when connFd is closed
+var errSocketClosed = errors.New("socket is closed")
+
+// errStopped is returned by waitReadable when its done channel is closed
+var errStopped = errors.New("stopped")
+
+// socketID identifies the socket behind connFd. Once connFd is closed its number may be given
+// to a new socket, comparing IDs tells them apart
+func socketID(connFd int) (uint64, error) {
+	var st unix.Stat_t
+	if err := unix.Fstat(connFd, &st); err != nil {
+		if errors.Is(err, unix.EBADF) {
+			return 0, errSocketClosed
+		}
+		return 0, err
+	}
+	return st.Ino, nil
+}
+
+// waitReadable blocks until there is a packet to read from connFd, which must still be the socket id,
+// or done is closed. done may be nil.
+// Sockets of net.UDPConn are non-blocking, reading them right away returns EAGAIN if nothing arrived yet.
+// Closing connFd doesn't wake up poll, so it's called with a timeout to notice that
+func waitReadable(connFd int, id uint64, done <-chan struct{}) error {
+	fds := []unix.PollFd{{Fd: int32(connFd), Events: unix.POLLIN}}
+	for {
+		select {
+		case <-done:
+			return errStopped
+		default:
+		}
+		n, err := unix.Poll(fds, pollTimeoutMs)
+		if err != nil {
+			if errors.Is(err, unix.EINTR) {
+				continue
+			}
+			return err
+		}
+		if n > 0 && fds[0].Revents&unix.POLLNVAL != 0 {
+			return errSocketClosed
+		}
+		// connFd may have been closed and reused by another socket while waiting
+		cur, err := socketID(connFd)
+		if err != nil {
+			return err
+		}
+		if cur != id {
+			return errSocketClosed
+		}
+		if n == 0 {
+			continue
+		}
+		if fds[0].Revents&unix.POLLIN != 0 {
+			return nil
+		}
+		if fds[0].Revents&unix.POLLERR != 0 {
+			// TX timestamps nobody picked up keep poll reporting an error, drop them
+			drainErrQueue(connFd)
+		}
+	}
+}
+
+// drainErrQueue discards everything queued on the error queue of connFd
+func drainErrQueue(connFd int) {
+	buf := make([]byte, requestBufferSizeBytes)
+	oob := make([]byte, timestamp.ControlSizeBytes)
+	for {
+		if _, _, _, _, err := unix.Recvmsg(connFd, buf, oob, unix.MSG_ERRQUEUE|unix.MSG_DONTWAIT); err != nil {
+			return
+		}
+	}
+}
+
+// isClosed tells if err means connFd was closed while reading from or writing to it
+func isClosed(err error) bool {
+	return errors.Is(err, errSocketClosed) || errors.Is(err, unix.EBADF)
+}
+
+// isTemporary tells if reading from connFd failed because nothing was there to read and should be retried
+func isTemporary(err error) bool {
+	return errors.Is(err, unix.EAGAIN) || errors.Is(err, unix.EINTR)
+}
+
+// mmsghdr is struct mmsghdr from include/linux/socket.h
+type mmsghdr struct {
+	hdr unix.Msghdr
+	len uint32
+}
+
+// ReadNTPPackets reads up to max incoming NTP packets in a single recvmmsg syscall.
+// It blocks until at least one packet is available, connFd may be blocking or non-blocking
+// (as the one of net.UDPConn is).
+// Datagrams which can't be parsed as NTP packets or don't fit into requestBufferSizeBytes are dropped
+func ReadNTPPackets(connFd int, max int) ([]*Packet, []net.Addr, error) {
+	if max <= 0 {
+		return nil, nil, fmt.Errorf("invalid number of packets to read: %d", max)
+	}
+	msgs := make([]mmsghdr, max)
+	iovs := make([]unix.Iovec, max)
+	names := make([]unix.RawSockaddrAny, max)
+	buf := make([]byte, max*requestBufferSizeBytes)
+
+	for i := range msgs {
+		iovs[i].Base = &buf[i*requestBufferSizeBytes]
+		iovs[i].SetLen(requestBufferSizeBytes)
+		msgs[i].hdr.Name = (*byte)(unsafe.Pointer(&names[i]))
+		msgs[i].hdr.Namelen = uint32(unsafe.Sizeof(names[i]))
+		msgs[i].hdr.Iov = &iovs[i]
+		msgs[i].hdr.SetIovlen(1)
+	}
+
+	id, err := socketID(connFd)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read packets: %w", err)
+	}
+	var r uintptr
+	for {
+		var errno unix.Errno
+		r, _, errno = unix.Syscall6(unix.SYS_RECVMMSG, uintptr(connFd), uintptr(unsafe.Pointer(&msgs[0])), uintptr(max), unix.MSG_WAITFORONE, 0, 0)
+		if errno == 0 {
+			break
+		}
+		if !isTemporary(errno) {
+			return nil, nil, fmt.Errorf("failed to read packets: %w", errno)
+		}
+		if errno == unix.EAGAIN {
+			if err := waitReadable(connFd, id, nil); err != nil {
+				return nil, nil, fmt.Errorf("failed to read packets: %w", err)
+			}
+		}
+	}
+
+	n := int(r)
+	packets := make([]*Packet, 0, n)
+	addrs := make([]net.Addr, 0, n)
+	for i := 0; i < n; i++ {
+		if msgs[i].hdr.Flags&unix.MSG_TRUNC != 0 {
+			// too big for NTP packet we accept
+			continue
+		}
+		b := buf[i*requestBufferSizeBytes : i*requestBufferSizeBytes+int(msgs[i].len)]
+		packet, err := BytesToPacket(b)
+		if err != nil {
+			continue
+		}
+		addr := rawSockaddrToUDPAddr(&names[i])
+		if addr == nil {
+			continue
+		}
+		packets = append(packets, packet)
+		addrs = append(addrs, addr)
+	}
+	return packets, addrs, nil
+}
+
+// rawSockaddrToUDPAddr converts raw socket address filled by the kernel into *net.UDPAddr
+func rawSockaddrToUDPAddr(rsa *unix.RawSockaddrAny) *net.UDPAddr {
+	switch rsa.Addr.Family {
+	case unix.AF_INET:
+		pp := (*unix.RawSockaddrInet4)(unsafe.Pointer(rsa))
+		sa := &unix.SockaddrInet4{Port: ntohs(pp.Port), Addr: pp.Addr}
+		return sockaddrToUDPAddr(sa)
+	case unix.AF_INET6:
+		pp := (*unix.RawSockaddrInet6)(unsafe.Pointer(rsa))
+		sa := &unix.SockaddrInet6{Port: ntohs(pp.Port), ZoneId: pp.Scope_id, Addr: pp.Addr}
+		return sockaddrToUDPAddr(sa)
+	}
+	return nil
+}
+
+// ntohs converts port stored in network byte order
+func ntohs(port uint16) int {
+	p := (*[2]byte)(unsafe.Pointer(&port))
+	return int(p[0])<<8 + int(p[1])
+}
+
+// sockaddrToUDPAddr converts socket address into *net.UDPAddr
+func sockaddrToUDPAddr(sa unix.Sockaddr) *net.UDPAddr {
+	switch sa := sa.(type) {
+	case *unix.SockaddrInet4:
+		return &net.UDPAddr{IP: timestamp.SockaddrToIP(sa), Port: sa.Port}
+	case *unix.SockaddrInet6:
+		addr := &net.UDPAddr{IP: timestamp.SockaddrToIP(sa), Port: sa.Port}
+		// link-local addresses are meaningless without the zone
+		if sa.ZoneId != 0 {
+			if iface, err := net.InterfaceByIndex(int(sa.ZoneId)); err == nil {
+				addr.Zone = iface.Name
+			}
+		}
+		return addr
+	}
+	return nil
+}
+
+// ReadNTPPacketWithRxTimestamp reads incoming NTP packet along with kernel RX timestamp.
+// Kernel timestamps must be enabled on the socket beforehand (for example with timestamp.EnableSWTimestampsRx)
+func ReadNTPPacketWithRxTimestamp(connFd int) (*Packet, net.Addr, time.Time, error) {
+	ntp, addr, rxTS, _, err := ReadNTPPacketWithRxTimestampSource(connFd)
+	return ntp, addr, rxTS, err
+}
+
+// ReadNTPPacketWithRxTimestampSource is ReadNTPPacketWithRxTimestamp which also reports
+// whether RX timestamp is a hardware or a software one
+func ReadNTPPacketWithRxTimestampSource(connFd int) (*Packet, net.Addr, time.Time, TimestampSource, error) {
+	buf := make([]byte, timestamp.PayloadSizeBytes)
+	oob := make([]byte, timestamp.ControlSizeBytes)
+	n, sa, rxTS, hw, err := timestamp.ReadPacketWithRXTimestampSourceBuf(connFd, buf, oob)
+	if err != nil {
+		return nil, nil, time.Time{}, SourceSoftware, err
+	}
+	ntp, err := BytesToPacket(buf[:n])
+	if err != nil {
+		return nil, nil, time.Time{}, SourceSoftware, err
+	}
+	return ntp, sockaddrToUDPAddr(sa), rxTS, timestampSource(hw), nil
+}
@@ -0,0 +1,308 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package protocol
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/facebook/time/timestamp"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+func TestReadNTPPackets(t *testing.T) {
+	// listen to incoming udp packets
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 0})
+	require.NoError(t, err)
+	defer conn.Close()
+
+	connFd, err := timestamp.ConnFd(conn)
+	require.NoError(t, err)
+	err = unix.SetNonblock(connFd, false)
+	require.NoError(t, err)
+
+	cconn, err := net.Dial("udp", conn.LocalAddr().String())
+	require.NoError(t, err)
+	defer cconn.Close()
+
+	for i := 0; i < 10; i++ {
+		_, err = cconn.Write(ntpRequestBytes)
+		require.NoError(t, err)
+	}
+
+	packets, addrs, err := ReadNTPPackets(connFd, 10)
+	require.NoError(t, err)
+	require.Equal(t, 10, len(packets))
+	require.Equal(t, 10, len(addrs))
+	for i := range packets {
+		require.Equal(t, ntpRequest, packets[i])
+		require.Equal(t, cconn.LocalAddr().String(), addrs[i].String())
+	}
+}
+
+func TestReadNTPPacketsNonBlocking(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 0})
+	require.NoError(t, err)
+	defer conn.Close()
+	// fd of net.UDPConn is left non-blocking
+	connFd, err := timestamp.ConnFd(conn)
+	require.NoError(t, err)
+
+	cconn, err := net.Dial("udp", conn.LocalAddr().String())
+	require.NoError(t, err)
+	defer cconn.Close()
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		_, _ = cconn.Write(ntpRequestBytes)
+	}()
+	packets, _, err := ReadNTPPackets(connFd, 10)
+	require.NoError(t, err)
+	require.Equal(t, []*Packet{ntpRequest}, packets)
+}
+
+func TestReadNTPPacketsExtensions(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 0})
+	require.NoError(t, err)
+	defer conn.Close()
+	connFd, err := timestamp.ConnFd(conn)
+	require.NoError(t, err)
+
+	cconn, err := net.Dial("udp", conn.LocalAddr().String())
+	require.NoError(t, err)
+	defer cconn.Close()
+
+	p := NewClientPacket()
+	p.AppendExtension(ExtensionField{Type: 0x0104, Value: make([]byte, 28)})
+	b, err := p.Bytes()
+	require.NoError(t, err)
+	_, err = cconn.Write(b)
+	require.NoError(t, err)
+	// doesn't fit into the read buffer, dropped instead of being parsed truncated
+	_, err = cconn.Write(make([]byte, requestBufferSizeBytes+PacketSizeBytes))
+	require.NoError(t, err)
+	_, err = cconn.Write(ntpRequestBytes)
+	require.NoError(t, err)
+
+	var packets []*Packet
+	for len(packets) < 2 {
+		got, _, err := ReadNTPPackets(connFd, 10)
+		require.NoError(t, err)
+		packets = append(packets, got...)
+	}
+	require.Equal(t, []*Packet{p, ntpRequest}, packets)
+}
+
+func TestReadNTPPacketsDropsMalformed(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 0})
+	require.NoError(t, err)
+	defer conn.Close()
+
+	connFd, err := timestamp.ConnFd(conn)
+	require.NoError(t, err)
+	err = unix.SetNonblock(connFd, false)
+	require.NoError(t, err)
+
+	cconn, err := net.Dial("udp", conn.LocalAddr().String())
+	require.NoError(t, err)
+	defer cconn.Close()
+
+	_, err = cconn.Write([]byte{1, 2, 3})
+	require.NoError(t, err)
+	_, err = cconn.Write(ntpRequestBytes)
+	require.NoError(t, err)
+
+	packets, addrs, err := ReadNTPPackets(connFd, 2)
+	require.NoError(t, err)
+	require.Equal(t, []*Packet{ntpRequest}, packets)
+	require.Equal(t, 1, len(addrs))
+}
+
+func TestReadNTPPacketsInvalidMax(t *testing.T) {
+	_, _, err := ReadNTPPackets(0, 0)
+	require.Error(t, err)
+}
+
+func Benchmark_ServerBatchRead(b *testing.B) {
+	const batch = 32
+	// Server
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("localhost"), Port: 0})
+	require.Nil(b, err)
+	defer conn.Close()
+
+	connFd, err := timestamp.ConnFd(conn)
+	require.NoError(b, err)
+	err = unix.SetNonblock(connFd, false)
+	require.NoError(b, err)
+
+	// Client
+	addr, err := net.ResolveUDPAddr("udp", conn.LocalAddr().String())
+	require.Nil(b, err)
+	cconn, err := net.DialUDP("udp", nil, addr)
+	require.Nil(b, err)
+	defer cconn.Close()
+
+	for i := 0; i < b.N; i += batch {
+		for j := 0; j < batch; j++ {
+			_, _ = cconn.Write(ntpRequestBytes)
+		}
+		for read := 0; read < batch; {
+			packets, _, err := ReadNTPPackets(connFd, batch)
+			require.NoError(b, err)
+			read += len(packets)
+		}
+	}
+}
+
+func Benchmark_ServerBatchEcho(b *testing.B) {
+	const batch = 32
+	// Server
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("localhost"), Port: 0})
+	require.Nil(b, err)
+	defer conn.Close()
+
+	connFd, err := timestamp.ConnFd(conn)
+	require.NoError(b, err)
+	err = unix.SetNonblock(connFd, false)
+	require.NoError(b, err)
+
+	// Client
+	addr, err := net.ResolveUDPAddr("udp", conn.LocalAddr().String())
+	require.Nil(b, err)
+	cconn, err := net.DialUDP("udp", nil, addr)
+	require.Nil(b, err)
+	defer cconn.Close()
+
+	for i := 0; i < b.N; i += batch {
+		for j := 0; j < batch; j++ {
+			_, _ = cconn.Write(ntpRequestBytes)
+		}
+		for read := 0; read < batch; {
+			packets, addrs, err := ReadNTPPackets(connFd, batch)
+			require.NoError(b, err)
+			for k := range packets {
+				_ = WritePacket(conn, packets[k], addrs[k])
+			}
+			read += len(packets)
+		}
+	}
+}
+
+// sendAndReadWithRxTimestamp sends ntpRequestBytes over cconn and reads it with rx timestamp from connFd.
+// Kernel enables rx timestamping asynchronously, so packets sent right after enabling may arrive without it
+func sendAndReadWithRxTimestamp(t *testing.T, cconn net.Conn, connFd int) (*Packet, net.Addr, time.Time) {
+	var request *Packet
+	var returnaddr net.Addr
+	var rxTS time.Time
+	var err error
+	for i := 0; i < 10; i++ {
+		_, err = cconn.Write(ntpRequestBytes)
+		require.NoError(t, err)
+		request, returnaddr, rxTS, err = ReadNTPPacketWithRxTimestamp(connFd)
+		if err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	require.NoError(t, err)
+	return request, returnaddr, rxTS
+}
+
+func TestReadNTPPacketWithRxTimestampIPv6(t *testing.T) {
+	conn, err := net.ListenUDP("udp6", &net.UDPAddr{IP: net.IPv6loopback, Port: 0})
+	if err != nil {
+		t.Skipf("IPv6 is not available: %v", err)
+	}
+	defer conn.Close()
+
+	connFd, err := timestamp.ConnFd(conn)
+	require.NoError(t, err)
+	err = timestamp.EnableSWTimestampsRx(connFd)
+	require.NoError(t, err)
+	err = unix.SetNonblock(connFd, false)
+	require.NoError(t, err)
+
+	cconn, err := net.Dial("udp6", conn.LocalAddr().String())
+	require.NoError(t, err)
+	defer cconn.Close()
+
+	request, returnaddr, _ := sendAndReadWithRxTimestamp(t, cconn, connFd)
+	require.Equal(t, ntpRequest, request)
+	require.Equal(t, cconn.LocalAddr(), returnaddr)
+}
+
+func TestReadNTPPacketWithRxTimestamp(t *testing.T) {
+	// listen to incoming udp packets
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("localhost"), Port: 0})
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// get connection file descriptor
+	connFd, err := timestamp.ConnFd(conn)
+	require.NoError(t, err)
+
+	// Allow reading of kernel timestamps via socket
+	err = timestamp.EnableSWTimestampsRx(connFd)
+	require.NoError(t, err)
+
+	err = unix.SetNonblock(connFd, false)
+	require.NoError(t, err)
+
+	// Send a client request
+	cconn, err := net.Dial("udp", conn.LocalAddr().String())
+	require.NoError(t, err)
+	defer cconn.Close()
+	sent := time.Now()
+	request, returnaddr, rxTS := sendAndReadWithRxTimestamp(t, cconn, connFd)
+	require.Equal(t, ntpRequest, request, "We should have the same request arriving on the server")
+	require.Equal(t, cconn.LocalAddr().String(), returnaddr.String())
+	require.WithinDuration(t, sent, rxTS, time.Second)
+}
+
+func TestReadNTPPacketWithRxTimestampSource(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 0})
+	require.NoError(t, err)
+	defer conn.Close()
+
+	connFd, err := timestamp.ConnFd(conn)
+	require.NoError(t, err)
+	err = timestamp.EnableSWTimestampsRx(connFd)
+	require.NoError(t, err)
+	err = unix.SetNonblock(connFd, false)
+	require.NoError(t, err)
+
+	cconn, err := net.Dial("udp", conn.LocalAddr().String())
+	require.NoError(t, err)
+	defer cconn.Close()
+
+	var request *Packet
+	var source TimestampSource
+	for i := 0; i < 10; i++ {
+		_, err = cconn.Write(ntpRequestBytes)
+		require.NoError(t, err)
+		request, _, _, source, err = ReadNTPPacketWithRxTimestampSource(connFd)
+		if err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	require.NoError(t, err)
+	require.Equal(t, ntpRequest, request)
+	require.Equal(t, SourceSoftware, source)
+}
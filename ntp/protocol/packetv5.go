@@ -0,0 +1,156 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package protocol
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// PacketV5SizeBytes is the size of NTPv5 packet header
+const PacketV5SizeBytes = 48
+
+const vnV5 = 5
+
+// NTPv5 timescales
+const (
+	TimescaleUTC            = 0
+	TimescaleTAI            = 1
+	TimescaleUT1            = 2
+	TimescaleLeapSmearedUTC = 3
+)
+
+// NTPv5 flags
+const (
+	FlagUnknownLeap = 0x1
+	FlagInterleaved = 0x2
+	FlagAuthNAK     = 0x4
+)
+
+// PacketV5 is an NTPv5 packet header as per draft-ietf-ntp-ntpv5. Extension fields are not decoded
+/*
+   0                   1                   2                   3
+   0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1
+0 +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+  |LI | VN  |Mode |    Stratum    |     Poll      |   Precision   |
+4 +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+  |   Timescale   |      Era      |             Flags             |
+8 +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+  |                          Root Delay                           |
+12+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+  |                        Root Dispersion                        |
+16+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+  |                                                               |
+  +                      Server Cookie (64)                       +
+  |                                                               |
+24+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+  |                                                               |
+  +                      Client Cookie (64)                       +
+  |                                                               |
+32+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+  |                                                               |
+  +                      Receive Timestamp (64)                   +
+  |                                                               |
+40+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+  |                                                               |
+  +                      Transmit Timestamp (64)                  +
+  |                                                               |
+48+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+*/
+type PacketV5 struct {
+	Settings       uint8  // leap indicator, version number and mode
+	Stratum        uint8  // stratum
+	Poll           int8   // poll. Power of 2
+	Precision      int8   // precision. Power of 2
+	Timescale      uint8  // timescale of the timestamps
+	Era            uint8  // NTP era of the receive timestamp
+	Flags          uint16 // flags
+	RootDelay      uint32 // total delay to the reference clock
+	RootDispersion uint32 // total dispersion to the reference clock
+	ServerCookie   uint64 // server cookie used in interleaved mode
+	ClientCookie   uint64 // client cookie the server echoes back
+	RxTimeSec      uint32 // receive time sec
+	RxTimeFrac     uint32 // receive time frac
+	TxTimeSec      uint32 // transmit time sec
+	TxTimeFrac     uint32 // transmit time frac
+}
+
+// DetectVersion returns NTP version of the packet in b, or 0 if b is empty
+func DetectVersion(b []byte) uint8 {
+	if len(b) == 0 {
+		return 0
+	}
+	return (b[0] >> 3) & 0x7
+}
+
+// Version returns the VN field of Settings (middle 3 bits)
+func (p *PacketV5) Version() uint8 {
+	return (p.Settings >> 3) & 0x7
+}
+
+// Mode returns the Mode field of Settings (bottom 3 bits)
+func (p *PacketV5) Mode() uint8 {
+	return p.Settings & 0x7
+}
+
+// Bytes converts PacketV5 to []bytes
+func (p *PacketV5) Bytes() ([]byte, error) {
+	b := make([]byte, PacketV5SizeBytes)
+	b[0] = p.Settings
+	b[1] = p.Stratum
+	b[2] = byte(p.Poll)
+	b[3] = byte(p.Precision)
+	b[4] = p.Timescale
+	b[5] = p.Era
+	binary.BigEndian.PutUint16(b[6:], p.Flags)
+	binary.BigEndian.PutUint32(b[8:], p.RootDelay)
+	binary.BigEndian.PutUint32(b[12:], p.RootDispersion)
+	binary.BigEndian.PutUint64(b[16:], p.ServerCookie)
+	binary.BigEndian.PutUint64(b[24:], p.ClientCookie)
+	binary.BigEndian.PutUint32(b[32:], p.RxTimeSec)
+	binary.BigEndian.PutUint32(b[36:], p.RxTimeFrac)
+	binary.BigEndian.PutUint32(b[40:], p.TxTimeSec)
+	binary.BigEndian.PutUint32(b[44:], p.TxTimeFrac)
+	return b, nil
+}
+
+// BytesToPacketV5 converts []bytes to PacketV5. Anything after the header is ignored
+func BytesToPacketV5(b []byte) (*PacketV5, error) {
+	if len(b) < PacketV5SizeBytes {
+		return nil, fmt.Errorf("%w: got %d bytes, expected %d", ErrShortPacket, len(b), PacketV5SizeBytes)
+	}
+	if v := DetectVersion(b); v != vnV5 {
+		return nil, fmt.Errorf("%w: %d", ErrInvalidVersion, v)
+	}
+	return &PacketV5{
+		Settings:       b[0],
+		Stratum:        b[1],
+		Poll:           int8(b[2]),
+		Precision:      int8(b[3]),
+		Timescale:      b[4],
+		Era:            b[5],
+		Flags:          binary.BigEndian.Uint16(b[6:]),
+		RootDelay:      binary.BigEndian.Uint32(b[8:]),
+		RootDispersion: binary.BigEndian.Uint32(b[12:]),
+		ServerCookie:   binary.BigEndian.Uint64(b[16:]),
+		ClientCookie:   binary.BigEndian.Uint64(b[24:]),
+		RxTimeSec:      binary.BigEndian.Uint32(b[32:]),
+		RxTimeFrac:     binary.BigEndian.Uint32(b[36:]),
+		TxTimeSec:      binary.BigEndian.Uint32(b[40:]),
+		TxTimeFrac:     binary.BigEndian.Uint32(b[44:]),
+	}, nil
+}
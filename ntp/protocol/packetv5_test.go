@@ -0,0 +1,83 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package protocol
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+var v5Bytes = []byte{
+	0x2c, 0x01, 0x03, 0xe8, // LI 0, VN 5, Mode 4, stratum 1, poll 3, precision -24
+	0x01, 0x00, 0x00, 0x02, // timescale TAI, era 0, flags interleaved
+	0x00, 0x00, 0x00, 0x10, // root delay
+	0x00, 0x00, 0x00, 0x20, // root dispersion
+	0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, // server cookie
+	0x11, 0x12, 0x13, 0x14, 0x15, 0x16, 0x17, 0x18, // client cookie
+	0xe5, 0x40, 0x4a, 0x62, 0x1b, 0x5d, 0x00, 0x00, // rx
+	0xe5, 0x40, 0x4a, 0x62, 0x1b, 0x5e, 0x00, 0x00, // tx
+}
+
+var v5Packet = &PacketV5{
+	Settings:       0x2c,
+	Stratum:        1,
+	Poll:           3,
+	Precision:      -24,
+	Timescale:      TimescaleTAI,
+	Era:            0,
+	Flags:          FlagInterleaved,
+	RootDelay:      0x10,
+	RootDispersion: 0x20,
+	ServerCookie:   0x0102030405060708,
+	ClientCookie:   0x1112131415161718,
+	RxTimeSec:      0xe5404a62,
+	RxTimeFrac:     0x1b5d0000,
+	TxTimeSec:      0xe5404a62,
+	TxTimeFrac:     0x1b5e0000,
+}
+
+func TestBytesToPacketV5(t *testing.T) {
+	p, err := BytesToPacketV5(v5Bytes)
+	require.NoError(t, err)
+	require.Equal(t, v5Packet, p)
+	require.Equal(t, uint8(5), p.Version())
+	require.Equal(t, uint8(modeServer), p.Mode())
+
+	b, err := p.Bytes()
+	require.NoError(t, err)
+	require.Equal(t, v5Bytes, b)
+}
+
+func TestBytesToPacketV5Errors(t *testing.T) {
+	_, err := BytesToPacketV5(v5Bytes[:47])
+	require.ErrorIs(t, err, ErrShortPacket)
+
+	v4, err := ntpResponse.Bytes()
+	require.NoError(t, err)
+	_, err = BytesToPacketV5(v4)
+	require.ErrorIs(t, err, ErrInvalidVersion)
+}
+
+func TestDetectVersion(t *testing.T) {
+	require.Equal(t, uint8(5), DetectVersion(v5Bytes))
+	v4, err := ntpResponse.Bytes()
+	require.NoError(t, err)
+	require.Equal(t, ntpResponse.Version(), DetectVersion(v4))
+	require.Equal(t, uint8(3), DetectVersion([]byte{0x1b}))
+	require.Equal(t, uint8(0), DetectVersion(nil))
+}
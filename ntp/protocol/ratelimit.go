@@ -0,0 +1,126 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package protocol
+
+import (
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// maxTrackedSources is how many sources RateLimiter tracks at most. Once reached, idle ones are forgotten first
+const maxTrackedSources = 65536
+
+// evictBatch is how many sources at least are forgotten once maxTrackedSources is reached,
+// so the map isn't scanned on every new source during a flood
+const evictBatch = maxTrackedSources / 8
+
+// bucket is a token bucket of a single source
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// RateLimiter limits request rate per source IP using token buckets.
+// Each source may send burst requests at once, and the bucket refills at rate requests per second
+type RateLimiter struct {
+	burst float64
+	rate  float64
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	now     func() time.Time
+}
+
+// NewRateLimiter returns RateLimiter allowing bursts of burst requests refilled at rate requests per second
+func NewRateLimiter(burst int, rate float64) *RateLimiter {
+	return &RateLimiter{
+		burst:   float64(burst),
+		rate:    rate,
+		buckets: map[string]*bucket{},
+		now:     time.Now,
+	}
+}
+
+// sourceKey returns IP of the addr, ignoring the port
+func sourceKey(addr net.Addr) string {
+	if udpAddr, ok := addr.(*net.UDPAddr); ok {
+		return udpAddr.IP.String()
+	}
+	return addr.String()
+}
+
+// Allow returns true if the request from addr is within the limit.
+// Server should reply with a Kiss-o'-Death (see NewKissOfDeathResponse with KissCodeRate) or drop it otherwise
+func (rl *RateLimiter) Allow(addr net.Addr) bool {
+	key := sourceKey(addr)
+	now := rl.now()
+
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	b, ok := rl.buckets[key]
+	if !ok {
+		if len(rl.buckets) >= maxTrackedSources {
+			rl.forgetIdle(now)
+			// during a flood from many (likely spoofed) sources buckets don't refill,
+			// forget the least recently seen ones to keep memory bounded
+			rl.forgetOldest(maxTrackedSources - evictBatch)
+		}
+		b = &bucket{tokens: rl.burst, last: now}
+		rl.buckets[key] = b
+	}
+
+	b.tokens += now.Sub(b.last).Seconds() * rl.rate
+	if b.tokens > rl.burst {
+		b.tokens = rl.burst
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// forgetIdle removes buckets which are full again, as they are the same as new ones
+func (rl *RateLimiter) forgetIdle(now time.Time) {
+	for key, b := range rl.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*rl.rate >= rl.burst {
+			delete(rl.buckets, key)
+		}
+	}
+}
+
+// forgetOldest removes the least recently seen buckets until at most n are left
+func (rl *RateLimiter) forgetOldest(n int) {
+	if len(rl.buckets) <= n {
+		return
+	}
+	keys := make([]string, 0, len(rl.buckets))
+	for key := range rl.buckets {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return rl.buckets[keys[i]].last.Before(rl.buckets[keys[j]].last)
+	})
+	for _, key := range keys[:len(keys)-n] {
+		delete(rl.buckets, key)
+	}
+}
@@ -0,0 +1,95 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package protocol
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Unix(usec, 0)
+	rl := NewRateLimiter(3, 1)
+	rl.now = func() time.Time { return now }
+
+	abuser := &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 123}
+	abuserOtherPort := &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 4242}
+	victim := &net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: 123}
+
+	// burst is allowed
+	for i := 0; i < 3; i++ {
+		require.True(t, rl.Allow(abuser))
+	}
+	// then throttled regardless of the port
+	require.False(t, rl.Allow(abuser))
+	require.False(t, rl.Allow(abuserOtherPort))
+
+	// other source is unaffected
+	require.True(t, rl.Allow(victim))
+
+	// bucket refills with time
+	now = now.Add(1500 * time.Millisecond)
+	require.True(t, rl.Allow(abuser))
+	require.False(t, rl.Allow(abuser))
+
+	// but not over the burst
+	now = now.Add(time.Hour)
+	for i := 0; i < 3; i++ {
+		require.True(t, rl.Allow(abuser))
+	}
+	require.False(t, rl.Allow(abuser))
+}
+
+func TestRateLimiterForgetIdle(t *testing.T) {
+	now := time.Unix(usec, 0)
+	rl := NewRateLimiter(1, 1)
+	rl.now = func() time.Time { return now }
+
+	idle := &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 123}
+	busy := &net.UDPAddr{IP: net.ParseIP("192.0.2.2"), Port: 123}
+	require.True(t, rl.Allow(idle))
+	now = now.Add(time.Second)
+	require.True(t, rl.Allow(busy))
+
+	rl.forgetIdle(now)
+	require.Equal(t, 1, len(rl.buckets))
+	require.NotNil(t, rl.buckets["192.0.2.2"])
+}
+
+func TestRateLimiterHardCap(t *testing.T) {
+	now := time.Unix(usec, 0)
+	// buckets never refill, so none of them is idle
+	rl := NewRateLimiter(1, 0)
+	rl.now = func() time.Time { return now }
+
+	addr := func(i int) net.Addr {
+		return &net.UDPAddr{IP: net.IPv4(10, byte(i>>16), byte(i>>8), byte(i)), Port: 123}
+	}
+	for i := 0; i < maxTrackedSources+evictBatch+10; i++ {
+		now = now.Add(time.Microsecond)
+		require.True(t, rl.Allow(addr(i)))
+		require.LessOrEqual(t, len(rl.buckets), maxTrackedSources)
+	}
+
+	// the most recent sources are still limited
+	require.False(t, rl.Allow(addr(maxTrackedSources+evictBatch+9)))
+	// the oldest ones were forgotten
+	require.Nil(t, rl.buckets[addr(0).(*net.UDPAddr).IP.String()])
+}
@@ -0,0 +1,102 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package protocol
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrReplay is returned when response was already seen
+var ErrReplay = errors.New("replayed response")
+
+// maxTrackedTimestamps is how many transmit timestamps ReplayDetector remembers at most.
+// Once reached, expired ones are forgotten first
+const maxTrackedTimestamps = 65536
+
+// replayEvictBatch is how many timestamps at least are forgotten once maxTrackedTimestamps is reached,
+// so the map isn't sorted on every new response
+const replayEvictBatch = maxTrackedTimestamps / 8
+
+// ReplayDetector remembers server transmit timestamps seen within a window and rejects duplicates.
+// A server never sends the same transmit timestamp twice, so a repeated one means the response was replayed.
+// At most maxTrackedTimestamps timestamps are remembered, the oldest ones are forgotten early if there are more
+type ReplayDetector struct {
+	window time.Duration
+
+	mu   sync.Mutex
+	seen map[NTPTimestamp]time.Time
+	now  func() time.Time
+}
+
+// NewReplayDetector returns ReplayDetector remembering responses for window
+func NewReplayDetector(window time.Duration) *ReplayDetector {
+	return &ReplayDetector{
+		window: window,
+		seen:   map[NTPTimestamp]time.Time{},
+		now:    time.Now,
+	}
+}
+
+// Check returns ErrReplay if response with the same transmit timestamp was checked within the window,
+// and remembers it otherwise
+func (rd *ReplayDetector) Check(response *Packet) error {
+	ts := response.TxTimestamp()
+	now := rd.now()
+
+	rd.mu.Lock()
+	defer rd.mu.Unlock()
+
+	if seen, ok := rd.seen[ts]; ok && now.Sub(seen) < rd.window {
+		return ErrReplay
+	}
+	if len(rd.seen) >= maxTrackedTimestamps {
+		rd.forgetExpired(now)
+		// a flood of distinct responses within the window must not grow the map without bound
+		rd.forgetOldest(maxTrackedTimestamps - replayEvictBatch)
+	}
+	rd.seen[ts] = now
+	return nil
+}
+
+// forgetExpired removes timestamps seen before the window
+func (rd *ReplayDetector) forgetExpired(now time.Time) {
+	for ts, seen := range rd.seen {
+		if now.Sub(seen) >= rd.window {
+			delete(rd.seen, ts)
+		}
+	}
+}
+
+// forgetOldest removes the earliest seen timestamps until at most n are left
+func (rd *ReplayDetector) forgetOldest(n int) {
+	if len(rd.seen) <= n {
+		return
+	}
+	timestamps := make([]NTPTimestamp, 0, len(rd.seen))
+	for ts := range rd.seen {
+		timestamps = append(timestamps, ts)
+	}
+	sort.Slice(timestamps, func(i, j int) bool {
+		return rd.seen[timestamps[i]].Before(rd.seen[timestamps[j]])
+	})
+	for _, ts := range timestamps[:len(timestamps)-n] {
+		delete(rd.seen, ts)
+	}
+}
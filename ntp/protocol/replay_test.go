@@ -0,0 +1,105 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package protocol
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReplayDetector(t *testing.T) {
+	rd := NewReplayDetector(time.Minute)
+	now := time.Unix(1600000000, 0)
+	rd.now = func() time.Time { return now }
+
+	require.NoError(t, rd.Check(ntpResponse))
+	require.ErrorIs(t, rd.Check(ntpResponse), ErrReplay)
+
+	other := *ntpResponse
+	other.TxTimeFrac++
+	require.NoError(t, rd.Check(&other))
+
+	// forgotten after the window
+	now = now.Add(time.Minute)
+	require.NoError(t, rd.Check(ntpResponse))
+	require.ErrorIs(t, rd.Check(ntpResponse), ErrReplay)
+}
+
+func TestReplayDetectorForgetExpired(t *testing.T) {
+	rd := NewReplayDetector(time.Second)
+	now := time.Unix(1600000000, 0)
+	rd.now = func() time.Time { return now }
+
+	p := &Packet{}
+	for i := 0; i < maxTrackedTimestamps; i++ {
+		p.TxTimeSec = uint32(i)
+		require.NoError(t, rd.Check(p))
+	}
+	now = now.Add(time.Second)
+	p.TxTimeSec = maxTrackedTimestamps
+	require.NoError(t, rd.Check(p))
+	require.Equal(t, 1, len(rd.seen))
+}
+
+func TestReplayDetectorHardCap(t *testing.T) {
+	rd := NewReplayDetector(time.Hour)
+	now := time.Unix(1600000000, 0)
+	rd.now = func() time.Time { return now }
+
+	// all within the window, none expires
+	p := &Packet{}
+	for i := 0; i < maxTrackedTimestamps+replayEvictBatch+10; i++ {
+		now = now.Add(time.Microsecond)
+		p.TxTimeSec = uint32(i)
+		require.NoError(t, rd.Check(p))
+		require.LessOrEqual(t, len(rd.seen), maxTrackedTimestamps)
+	}
+
+	// the most recent ones are still detected
+	require.ErrorIs(t, rd.Check(p), ErrReplay)
+	// the oldest ones were forgotten
+	p.TxTimeSec = 0
+	require.NoError(t, rd.Check(p))
+}
+
+func TestQueryReplayDetector(t *testing.T) {
+	replayed := make(chan []byte, 1)
+	conn := startStaticServer(t, func(req *Packet) []byte {
+		select {
+		case b := <-replayed:
+			// send the recorded response once more, matching the new request
+			p, _ := BytesToPacket(b)
+			p.SetOrigTimestamp(req.TxTimestamp())
+			b, _ = p.Bytes()
+			return b
+		default:
+		}
+		now := time.Now()
+		b, _ := NewServerResponse(req, now, now, 1, 0).Bytes()
+		replayed <- b
+		return b
+	})
+	defer conn.Close()
+
+	opts := QueryOptions{ReplayDetector: NewReplayDetector(time.Minute)}
+	_, err := QueryWithOptions(conn.LocalAddr().String(), time.Second, opts)
+	require.NoError(t, err)
+	_, err = QueryWithOptions(conn.LocalAddr().String(), time.Second, opts)
+	require.ErrorIs(t, err, ErrReplay)
+}
@@ -0,0 +1,114 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package protocol
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"time"
+)
+
+// requestBufferSizeBytes is a read buffer size, big enough for requests with extension fields
+const requestBufferSizeBytes = 1024
+
+// NewServerResponse builds server reply to the client request req.
+// recvTime is when request arrived, txTime is when reply departs
+func NewServerResponse(req *Packet, recvTime, txTime time.Time, stratum uint8, refID uint32) *Packet {
+	response := &Packet{
+		Stratum:     stratum,
+		Poll:        req.Poll,
+		ReferenceID: refID,
+	}
+	response.SetVersion(req.Version())
+	response.SetMode(modeServer)
+
+	// Originate Timestamp
+	// RFC: "Local time at which the request departed the client host for the service host."
+	response.OrigTimeSec = req.TxTimeSec
+	response.OrigTimeFrac = req.TxTimeFrac
+
+	// Receive Timestamp
+	// RFC: "Local time at which the request arrived at the service host."
+	response.SetReceiveTime(recvTime)
+
+	// Transmit Timestamp
+	// RFC: "Local time at which the reply departed the service host for the client host."
+	response.SetTransmitTime(txTime)
+
+	return response
+}
+
+// KissCodeRate tells the client to reduce its polling rate
+const KissCodeRate = "RATE"
+
+// NewKissOfDeathResponse builds Kiss-o'-Death reply to the client request req with ASCII kiss code (like RATE)
+func NewKissOfDeathResponse(req *Packet, code string) *Packet {
+	var refID [4]byte
+	copy(refID[:], code)
+	response := &Packet{
+		Poll:        req.Poll,
+		ReferenceID: binary.BigEndian.Uint32(refID[:]),
+	}
+	response.SetLeapIndicator(liAlarmCondition)
+	response.SetVersion(req.Version())
+	response.SetMode(modeServer)
+	response.OrigTimeSec = req.TxTimeSec
+	response.OrigTimeFrac = req.TxTimeFrac
+	return response
+}
+
+// Serve reads requests from conn, passes them to handler and sends returned responses back to the sender.
+// No reply is sent if handler returns nil. Serve returns nil once conn is closed
+func Serve(conn *net.UDPConn, handler func(req *Packet) *Packet) error {
+	return ServeWithStats(conn, handler, nil)
+}
+
+// ServeWithStats is Serve counting packets received and sent in stats
+func ServeWithStats(conn *net.UDPConn, handler func(req *Packet) *Packet, stats *Stats) error {
+	for {
+		request, addr, err := ReadNTPPacket(conn)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			if request == nil {
+				return err
+			}
+			// malformed request
+			stats.incReceived()
+			stats.incDecodeErrors()
+			continue
+		}
+		stats.incReceived()
+		response := handler(request)
+		if response == nil {
+			continue
+		}
+		b, err := response.Bytes()
+		if err != nil {
+			return err
+		}
+		if _, err := conn.WriteTo(b, addr); err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		stats.incSent()
+	}
+}
@@ -0,0 +1,129 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package protocol
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/facebook/time/timestamp"
+	"golang.org/x/sys/unix"
+)
+
+// txTimeOffsetBytes is an offset of the transmit timestamp in the packet header
+const txTimeOffsetBytes = 40
+
+// ReadTXTimestamp returns kernel TX timestamp of the last packet sent from connFd.
+// TX timestamps must be enabled on the socket beforehand: hardware ones with timestamp.EnableHWTimestamps
+// report the actual time packet left the NIC, software ones (timestamp.EnableSWTimestamps) the time it left the kernel
+func ReadTXTimestamp(connFd int) (time.Time, error) {
+	txTime, _, err := timestamp.ReadTXtimestamp(connFd)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to read TX timestamp: %w", err)
+	}
+	return txTime, nil
+}
+
+// ServeWithTimestamps reads requests along with kernel RX timestamps from connFd and passes them to handler.
+// Transmit timestamp of the returned response is overwritten right before it's sent.
+// If txHandler is not nil, it's called with the kernel TX timestamp of every response sent,
+// which requires TX timestamps to be enabled on the socket (for example with timestamp.EnableSWTimestamps).
+// Transmit timestamp of the response passed to txHandler is set to that wire time, so it can be sent
+// in the next interleaved reply.
+// No reply is sent if handler returns nil.
+// connFd may be blocking or non-blocking (as the one of net.UDPConn is). ServeWithTimestamps returns nil
+// once connFd is closed, which may take up to 100ms to notice while no requests arrive
+func ServeWithTimestamps(connFd int, handler func(req *Packet, rxTime time.Time) *Packet, txHandler func(resp *Packet, txTime time.Time)) error {
+	buf := make([]byte, requestBufferSizeBytes)
+	oob := make([]byte, timestamp.ControlSizeBytes)
+	var out []byte
+	id, err := socketID(connFd)
+	if err != nil {
+		if isClosed(err) {
+			return nil
+		}
+		return err
+	}
+
+	for {
+		n, sa, rxTime, err := timestamp.ReadPacketWithRXTimestampBuf(connFd, buf, oob)
+		if sa == nil {
+			if errors.Is(err, unix.EAGAIN) {
+				// nothing to read yet, only now pay for poll and socket checks
+				if err := waitReadable(connFd, id, nil); err != nil {
+					if isClosed(err) {
+						return nil
+					}
+					return err
+				}
+				continue
+			}
+			if isTemporary(err) {
+				continue
+			}
+			if isClosed(err) {
+				return nil
+			}
+			return err
+		}
+		if err != nil {
+			// kernel timestamp is missing, use the best we have
+			rxTime = time.Now()
+		}
+		request, err := BytesToPacket(buf[:n])
+		if err != nil {
+			// malformed request
+			continue
+		}
+
+		response := handler(request, rxTime)
+		if response == nil {
+			continue
+		}
+		size := response.size()
+		if cap(out) < size {
+			out = make([]byte, size)
+		}
+		out = out[:size]
+		if _, err := response.MarshalTo(out); err != nil {
+			return err
+		}
+
+		// stamp transmit time as late as possible
+		txSec, txFrac := Time(time.Now())
+		binary.BigEndian.PutUint32(out[txTimeOffsetBytes:], txSec)
+		binary.BigEndian.PutUint32(out[txTimeOffsetBytes+4:], txFrac)
+		if err := unix.Sendto(connFd, out, 0, sa); err != nil {
+			if isClosed(err) {
+				return nil
+			}
+			return err
+		}
+		response.TxTimeSec, response.TxTimeFrac = txSec, txFrac
+
+		if txHandler != nil {
+			txTime, err := ReadTXTimestamp(connFd)
+			if err != nil {
+				continue
+			}
+			response.SetTransmitTime(txTime)
+			txHandler(response, txTime)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package protocol
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/facebook/time/timestamp"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+// startTimestampingServer runs ServeWithTimestamps with stratum 1 handler on a loopback socket
+func startTimestampingServer(t testing.TB, txHandler func(resp *Packet, txTime time.Time)) (*net.UDPConn, chan time.Time) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 0})
+	require.NoError(t, err)
+	connFd, err := timestamp.ConnFd(conn)
+	require.NoError(t, err)
+	if txHandler != nil {
+		err = timestamp.EnableSWTimestamps(connFd)
+	} else {
+		err = timestamp.EnableSWTimestampsRx(connFd)
+	}
+	require.NoError(t, err)
+	err = unix.SetNonblock(connFd, false)
+	require.NoError(t, err)
+
+	rxTimes := make(chan time.Time, 100)
+	go func() {
+		_ = ServeWithTimestamps(connFd, func(req *Packet, rxTime time.Time) *Packet {
+			select {
+			case rxTimes <- rxTime:
+			default:
+			}
+			// transmit timestamp is deliberately stale, server must override it
+			return NewServerResponse(req, rxTime, time.Unix(0, 0), 1, 0)
+		}, txHandler)
+	}()
+	return conn, rxTimes
+}
+
+func TestServeWithTimestamps(t *testing.T) {
+	txTimes := make(chan time.Time, 1)
+	conn, rxTimes := startTimestampingServer(t, func(resp *Packet, txTime time.Time) {
+		// response carries the wire time
+		if d := resp.TxTimestamp().ToTime().Sub(txTime); d > time.Microsecond || d < -time.Microsecond {
+			return
+		}
+		select {
+		case txTimes <- txTime:
+		default:
+		}
+	})
+	defer conn.Close()
+
+	before := time.Now()
+	r, err := QueryResponse(conn.LocalAddr().String(), time.Second)
+	require.NoError(t, err)
+	rxTime := <-rxTimes
+	require.WithinDuration(t, before, rxTime, time.Second)
+	require.WithinDuration(t, rxTime, r.ServerReceiveTime, time.Microsecond)
+	require.False(t, r.ServerTransmitTime.Before(r.ServerReceiveTime))
+	require.False(t, r.ServerTransmitTime.After(r.ClientReceiveTime))
+
+	select {
+	case txTime := <-txTimes:
+		require.False(t, txTime.Before(r.ServerTransmitTime))
+	case <-time.After(time.Second):
+		t.Fatal("no TX timestamp reported")
+	}
+}
+
+func TestServeWithTimestampsClose(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 0})
+	require.NoError(t, err)
+	defer conn.Close()
+	// fd of net.UDPConn is left non-blocking
+	connFd, err := timestamp.ConnFd(conn)
+	require.NoError(t, err)
+	require.NoError(t, timestamp.EnableSWTimestamps(connFd))
+
+	served := make(chan struct{}, 10)
+	done := make(chan error, 1)
+	go func() {
+		done <- ServeWithTimestamps(connFd, func(req *Packet, rxTime time.Time) *Packet {
+			served <- struct{}{}
+			return NewServerResponse(req, rxTime, rxTime, 1, 0)
+		}, func(_ *Packet, _ time.Time) {})
+	}()
+
+	// nothing to read yet, the loop must keep waiting instead of returning EAGAIN
+	time.Sleep(50 * time.Millisecond)
+	select {
+	case err := <-done:
+		t.Fatalf("server exited with %v", err)
+	default:
+	}
+
+	_, err = QueryResponse(conn.LocalAddr().String(), time.Second)
+	require.NoError(t, err)
+	<-served
+
+	// let the loop block in the read again
+	time.Sleep(50 * time.Millisecond)
+	require.NoError(t, conn.Close())
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("server didn't stop after the socket was closed")
+	}
+}
+
+func TestReadTXTimestamp(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 0})
+	require.NoError(t, err)
+	defer conn.Close()
+
+	cconn, err := net.DialUDP("udp", nil, conn.LocalAddr().(*net.UDPAddr))
+	require.NoError(t, err)
+	defer cconn.Close()
+	connFd, err := timestamp.ConnFd(cconn)
+	require.NoError(t, err)
+	require.NoError(t, timestamp.EnableSWTimestamps(connFd))
+
+	before := time.Now()
+	require.NoError(t, WritePacket(cconn, ntpRequest, nil))
+	txTime, err := ReadTXTimestamp(connFd)
+	require.NoError(t, err)
+	require.False(t, txTime.Before(before))
+	require.WithinDuration(t, before, txTime, time.Second)
+}
+
+func TestReadTXTimestampDisabled(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 0})
+	require.NoError(t, err)
+	defer conn.Close()
+	connFd, err := timestamp.ConnFd(conn)
+	require.NoError(t, err)
+
+	_, err = ReadTXTimestamp(connFd)
+	require.Error(t, err)
+}
+
+/*
+Benchmark_ServerWithTimestamps is a benchmark to determine speed of
+full client-server exchange with kernel RX timestamps and late TX stamping
+*/
+func Benchmark_ServerWithTimestamps(b *testing.B) {
+	conn, _ := startTimestampingServer(b, nil)
+	defer conn.Close()
+
+	cconn, err := net.Dial("udp", conn.LocalAddr().String())
+	require.NoError(b, err)
+	defer cconn.Close()
+	buf := make([]byte, PacketSizeBytes)
+
+	for i := 0; i < b.N; i++ {
+		_, _ = cconn.Write(ntpRequestBytes)
+		_, _ = cconn.Read(buf)
+	}
+}
@@ -0,0 +1,87 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package protocol
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewServerResponse(t *testing.T) {
+	recvTime := time.Unix(usec, unsec)
+	txTime := recvTime.Add(10 * time.Microsecond)
+
+	response := NewServerResponse(ntpRequest, recvTime, txTime, 1, ntpResponse.ReferenceID)
+	require.Equal(t, ntpRequest.TxTimeSec, response.OrigTimeSec)
+	require.Equal(t, ntpRequest.TxTimeFrac, response.OrigTimeFrac)
+	require.Equal(t, uint8(4), response.Mode())
+	require.Equal(t, ntpRequest.Version(), response.Version())
+	require.Equal(t, uint8(1), response.Stratum)
+	require.Equal(t, "FB  ", response.RefIDString())
+	require.Equal(t, ntpRequest.Poll, response.Poll)
+
+	rxSec, rxFrac := Time(recvTime)
+	require.Equal(t, rxSec, response.RxTimeSec)
+	require.Equal(t, rxFrac, response.RxTimeFrac)
+	txSec, txFrac := Time(txTime)
+	require.Equal(t, txSec, response.TxTimeSec)
+	require.Equal(t, txFrac, response.TxTimeFrac)
+}
+
+func TestServe(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 0})
+	require.NoError(t, err)
+
+	served := make(chan error, 1)
+	go func() {
+		served <- Serve(conn, func(req *Packet) *Packet {
+			// ignore non-client packets
+			if req.Mode() != modeClient {
+				return nil
+			}
+			now := time.Now()
+			return NewServerResponse(req, now, now, 1, ntpResponse.ReferenceID)
+		})
+	}()
+
+	r, err := QueryResponse(conn.LocalAddr().String(), time.Second)
+	require.NoError(t, err)
+	require.Equal(t, uint8(1), r.Packet.Stratum)
+	require.Equal(t, "FB  ", r.Packet.RefIDString())
+	require.Equal(t, uint8(4), r.Packet.Mode())
+
+	// graceful shutdown
+	require.NoError(t, conn.Close())
+	select {
+	case err := <-served:
+		require.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("Serve didn't return after conn was closed")
+	}
+}
+
+func TestNewKissOfDeathResponse(t *testing.T) {
+	response := NewKissOfDeathResponse(ntpRequest, KissCodeRate)
+	require.True(t, response.IsKissOfDeath())
+	require.Equal(t, "RATE", response.KissCode())
+	require.Equal(t, uint8(3), response.LeapIndicator())
+	require.Equal(t, uint8(4), response.Mode())
+	require.Equal(t, ntpRequest.TxTimestamp(), response.OrigTimestamp())
+}
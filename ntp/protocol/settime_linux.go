@@ -0,0 +1,62 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package protocol
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// ErrNotPermitted is returned when process is not allowed to change system time
+var ErrNotPermitted = errors.New("changing system time requires CAP_SYS_TIME")
+
+// adjSetOffset is ADJ_SETOFFSET from include/uapi/linux/timex.h
+const adjSetOffset = 0x0100
+
+// stepClock shifts system clock by offset, tests replace it
+var stepClock = stepClockAdjtimex
+
+// SetSystemTime queries the server at address (host:port) once and steps system clock by the measured offset,
+// like ntpdate does. Kiss-o'-Death and unsynchronized responses are rejected. It requires CAP_SYS_TIME
+func SetSystemTime(address string) error {
+	offset, err := SNTP(address)
+	if err != nil {
+		return err
+	}
+	if err := stepClock(offset); err != nil {
+		return fmt.Errorf("failed to step clock by %v: %w", offset, err)
+	}
+	return nil
+}
+
+// stepClockAdjtimex shifts system clock by offset with adjtimex ADJ_SETOFFSET, with microsecond precision
+func stepClockAdjtimex(offset time.Duration) error {
+	tx := &unix.Timex{
+		Modes: adjSetOffset,
+		Time:  unix.NsecToTimeval(offset.Nanoseconds()),
+	}
+	if _, err := unix.Adjtimex(tx); err != nil {
+		if errors.Is(err, unix.EPERM) {
+			return ErrNotPermitted
+		}
+		return err
+	}
+	return nil
+}
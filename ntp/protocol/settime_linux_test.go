@@ -0,0 +1,63 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package protocol
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// mockStepClock replaces stepClock for the duration of the test and records offsets it was called with
+func mockStepClock(t *testing.T, err error) *[]time.Duration {
+	var steps []time.Duration
+	orig := stepClock
+	stepClock = func(offset time.Duration) error {
+		steps = append(steps, offset)
+		return err
+	}
+	t.Cleanup(func() { stepClock = orig })
+	return &steps
+}
+
+func TestSetSystemTime(t *testing.T) {
+	serverOffset := time.Hour
+	conn := startFakeServer(t, serverOffset)
+	defer conn.Close()
+	steps := mockStepClock(t, nil)
+
+	require.NoError(t, SetSystemTime(conn.LocalAddr().String()))
+	require.Len(t, *steps, 1)
+	require.InDelta(t, serverOffset.Nanoseconds(), (*steps)[0].Nanoseconds(), float64(100*time.Millisecond))
+}
+
+func TestSetSystemTimeErrors(t *testing.T) {
+	kod := startSlowFakeServer(t, 0, 0, 0)
+	defer kod.Close()
+	steps := mockStepClock(t, nil)
+	require.ErrorIs(t, SetSystemTime(kod.LocalAddr().String()), ErrKissOfDeath)
+	require.Empty(t, *steps)
+
+	conn := startFakeServer(t, time.Hour)
+	defer conn.Close()
+	mockStepClock(t, ErrNotPermitted)
+	err := SetSystemTime(conn.LocalAddr().String())
+	require.ErrorIs(t, err, ErrNotPermitted)
+	require.False(t, errors.Is(err, ErrKissOfDeath))
+}
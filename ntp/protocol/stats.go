@@ -0,0 +1,80 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package protocol
+
+import (
+	"sync/atomic"
+)
+
+// Stats counts packets handled by the client and server helpers. It's safe for concurrent use.
+// Counters must be read with Snapshot. Nil *Stats is valid and counts nothing
+type Stats struct {
+	// PacketsSent is the number of packets sent
+	PacketsSent int64
+	// PacketsReceived is the number of datagrams received, including malformed ones
+	PacketsReceived int64
+	// DecodeErrors is the number of received datagrams which couldn't be decoded as NTP packets
+	DecodeErrors int64
+	// KissOfDeath is the number of Kiss-o'-Death responses received
+	KissOfDeath int64
+	// Timeouts is the number of requests which weren't answered in time
+	Timeouts int64
+}
+
+// Snapshot returns a copy of current counters
+func (s *Stats) Snapshot() Stats {
+	if s == nil {
+		return Stats{}
+	}
+	return Stats{
+		PacketsSent:     atomic.LoadInt64(&s.PacketsSent),
+		PacketsReceived: atomic.LoadInt64(&s.PacketsReceived),
+		DecodeErrors:    atomic.LoadInt64(&s.DecodeErrors),
+		KissOfDeath:     atomic.LoadInt64(&s.KissOfDeath),
+		Timeouts:        atomic.LoadInt64(&s.Timeouts),
+	}
+}
+
+func (s *Stats) incSent() {
+	if s != nil {
+		atomic.AddInt64(&s.PacketsSent, 1)
+	}
+}
+
+func (s *Stats) incReceived() {
+	if s != nil {
+		atomic.AddInt64(&s.PacketsReceived, 1)
+	}
+}
+
+func (s *Stats) incDecodeErrors() {
+	if s != nil {
+		atomic.AddInt64(&s.DecodeErrors, 1)
+	}
+}
+
+func (s *Stats) incKissOfDeath() {
+	if s != nil {
+		atomic.AddInt64(&s.KissOfDeath, 1)
+	}
+}
+
+func (s *Stats) incTimeouts() {
+	if s != nil {
+		atomic.AddInt64(&s.Timeouts, 1)
+	}
+}
@@ -0,0 +1,115 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package protocol
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// startStaticServer replies to every request with reply built by reply func
+func startStaticServer(t *testing.T, reply func(req *Packet) []byte) *net.UDPConn {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 0})
+	require.NoError(t, err)
+	go func() {
+		for {
+			request, addr, err := ReadNTPPacket(conn)
+			if err != nil {
+				return
+			}
+			_, _ = conn.WriteTo(reply(request), addr)
+		}
+	}()
+	return conn
+}
+
+func TestStatsQuery(t *testing.T) {
+	conn := startFakeServer(t, 0)
+	defer conn.Close()
+
+	stats := &Stats{}
+	opts := QueryOptions{Stats: stats}
+	for i := 0; i < 3; i++ {
+		_, err := QueryWithOptions(conn.LocalAddr().String(), time.Second, opts)
+		require.NoError(t, err)
+	}
+	require.Equal(t, Stats{PacketsSent: 3, PacketsReceived: 3}, stats.Snapshot())
+
+	kod := startStaticServer(t, func(req *Packet) []byte {
+		b, _ := NewKissOfDeathResponse(req, KissCodeRate).Bytes()
+		return b
+	})
+	defer kod.Close()
+	_, err := QueryWithOptions(kod.LocalAddr().String(), time.Second, opts)
+	require.NoError(t, err)
+	require.Equal(t, Stats{PacketsSent: 4, PacketsReceived: 4, KissOfDeath: 1}, stats.Snapshot())
+
+	short := startStaticServer(t, func(req *Packet) []byte { return []byte{1, 2, 3} })
+	defer short.Close()
+	_, err = QueryWithOptions(short.LocalAddr().String(), time.Second, opts)
+	require.ErrorIs(t, err, ErrShortPacket)
+	require.Equal(t, Stats{PacketsSent: 5, PacketsReceived: 5, KissOfDeath: 1, DecodeErrors: 1}, stats.Snapshot())
+
+	silent, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 0})
+	require.NoError(t, err)
+	defer silent.Close()
+	_, err = QueryWithOptions(silent.LocalAddr().String(), 50*time.Millisecond, opts)
+	require.ErrorIs(t, err, ErrTimeout)
+	require.Equal(t, Stats{PacketsSent: 6, PacketsReceived: 5, KissOfDeath: 1, DecodeErrors: 1, Timeouts: 1}, stats.Snapshot())
+}
+
+func TestStatsServe(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 0})
+	require.NoError(t, err)
+	defer conn.Close()
+
+	stats := &Stats{}
+	go func() {
+		_ = ServeWithStats(conn, func(req *Packet) *Packet {
+			now := time.Now()
+			return NewServerResponse(req, now, now, 1, 0)
+		}, stats)
+	}()
+
+	for i := 0; i < 2; i++ {
+		_, err := QueryResponse(conn.LocalAddr().String(), time.Second)
+		require.NoError(t, err)
+	}
+	require.Eventually(t, func() bool {
+		return stats.Snapshot() == Stats{PacketsSent: 2, PacketsReceived: 2}
+	}, time.Second, 10*time.Millisecond)
+
+	// too short to be a request
+	cconn, err := net.Dial("udp", conn.LocalAddr().String())
+	require.NoError(t, err)
+	defer cconn.Close()
+	_, err = cconn.Write([]byte{1, 2, 3})
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		return stats.Snapshot() == Stats{PacketsSent: 2, PacketsReceived: 3, DecodeErrors: 1}
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestStatsNil(t *testing.T) {
+	var stats *Stats
+	stats.incSent()
+	stats.incTimeouts()
+	require.Equal(t, Stats{}, stats.Snapshot())
+}
@@ -0,0 +1,86 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package protocol
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/facebook/time/timestamp"
+)
+
+// TimestampedPacket is an NTP packet along with its sender and kernel RX timestamp
+type TimestampedPacket struct {
+	Packet *Packet
+	Addr   net.Addr
+	RxTime time.Time
+	// Source tells whether RxTime is a hardware or a software timestamp
+	Source TimestampSource
+}
+
+// PacketStream reads NTP packets along with kernel RX timestamps from connFd in background
+// and sends them to the returned channel. Kernel timestamps must be enabled on the socket beforehand,
+// current time is used if timestamp is missing. Malformed packets are dropped.
+// Calling returned func stops the stream and closes the channel, it doesn't close connFd
+func PacketStream(connFd int) (<-chan TimestampedPacket, func()) {
+	packets := make(chan TimestampedPacket)
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(packets)
+		buf := make([]byte, requestBufferSizeBytes)
+		oob := make([]byte, timestamp.ControlSizeBytes)
+		id, err := socketID(connFd)
+		if err != nil {
+			return
+		}
+		for {
+			// returns once connFd is closed, possibly reused by another socket, or the stream is stopped
+			if err := waitReadable(connFd, id, done); err != nil {
+				return
+			}
+			n, sa, rxTime, hw, err := timestamp.ReadPacketWithRXTimestampSourceBuf(connFd, buf, oob)
+			if sa == nil {
+				continue
+			}
+			if err != nil {
+				// kernel timestamp is missing, use the best we have
+				rxTime = time.Now()
+				hw = false
+			}
+			packet, err := BytesToPacket(buf[:n])
+			if err != nil {
+				continue
+			}
+			select {
+			case packets <- TimestampedPacket{Packet: packet, Addr: sockaddrToUDPAddr(sa), RxTime: rxTime, Source: timestampSource(hw)}:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	var once sync.Once
+	stop := func() {
+		once.Do(func() { close(done) })
+		wg.Wait()
+	}
+	return packets, stop
+}
@@ -0,0 +1,130 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package protocol
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/facebook/time/timestamp"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+func TestPacketStream(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 0})
+	require.NoError(t, err)
+	defer conn.Close()
+	connFd, err := timestamp.ConnFd(conn)
+	require.NoError(t, err)
+	require.NoError(t, timestamp.EnableSWTimestampsRx(connFd))
+
+	cconn, err := net.DialUDP("udp", nil, conn.LocalAddr().(*net.UDPAddr))
+	require.NoError(t, err)
+	defer cconn.Close()
+
+	packets, stop := PacketStream(connFd)
+	defer stop()
+
+	before := time.Now()
+	for i := 0; i < 3; i++ {
+		_, err := cconn.Write(ntpRequestBytes)
+		require.NoError(t, err)
+	}
+	// malformed one is dropped
+	_, err = cconn.Write([]byte{1, 2, 3})
+	require.NoError(t, err)
+	_, err = cconn.Write(ntpRequestBytes)
+	require.NoError(t, err)
+
+	for i := 0; i < 4; i++ {
+		select {
+		case p := <-packets:
+			require.Equal(t, ntpRequest, p.Packet)
+			require.Equal(t, cconn.LocalAddr().String(), p.Addr.String())
+			require.WithinDuration(t, before, p.RxTime, time.Second)
+			require.Equal(t, SourceSoftware, p.Source)
+		case <-time.After(time.Second):
+			t.Fatalf("packet %d not received", i)
+		}
+	}
+
+	stop()
+	_, ok := <-packets
+	require.False(t, ok)
+}
+
+func TestPacketStreamStopIdle(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 0})
+	require.NoError(t, err)
+	defer conn.Close()
+	connFd, err := timestamp.ConnFd(conn)
+	require.NoError(t, err)
+
+	packets, stop := PacketStream(connFd)
+	stopped := make(chan struct{})
+	go func() {
+		stop()
+		// stopping twice is fine
+		stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("stream didn't stop")
+	}
+	_, ok := <-packets
+	require.False(t, ok)
+}
+
+func TestPacketStreamDrainsErrQueue(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 0})
+	require.NoError(t, err)
+	defer conn.Close()
+	connFd, err := timestamp.ConnFd(conn)
+	require.NoError(t, err)
+	require.NoError(t, timestamp.EnableSWTimestamps(connFd))
+
+	cconn, err := net.DialUDP("udp", nil, conn.LocalAddr().(*net.UDPAddr))
+	require.NoError(t, err)
+	defer cconn.Close()
+
+	// TX timestamp of a packet sent from connFd ends up on its error queue, which makes poll report POLLERR
+	_, err = conn.WriteTo(ntpResponseBytes, cconn.LocalAddr())
+	require.NoError(t, err)
+	errQueueEmpty := func() bool {
+		fds := []unix.PollFd{{Fd: int32(connFd), Events: unix.POLLIN}}
+		_, err := unix.Poll(fds, 0)
+		return err == nil && fds[0].Revents&unix.POLLERR == 0
+	}
+	require.Eventually(t, func() bool { return !errQueueEmpty() }, time.Second, 10*time.Millisecond, "no TX timestamp queued")
+
+	packets, stop := PacketStream(connFd)
+	defer stop()
+	require.Eventually(t, errQueueEmpty, time.Second, 10*time.Millisecond, "error queue is not drained")
+
+	_, err = cconn.Write(ntpRequestBytes)
+	require.NoError(t, err)
+	select {
+	case p := <-packets:
+		require.Equal(t, ntpRequest, p.Packet)
+	case <-time.After(time.Second):
+		t.Fatal("packet not received")
+	}
+}
@@ -195,10 +195,15 @@ func TestServer(t *testing.T) {
 		}
 		response := &ntp.Packet{}
 
-		err = binary.Write(sendConn, binary.BigEndian, request)
+		requestBytes, err := request.Bytes()
+		require.Nil(t, err)
+		_, err = sendConn.Write(requestBytes)
 		require.Nil(t, err, "sending request should not err")
-		err = binary.Read(sendConn, binary.BigEndian, response)
+		responseBytes := make([]byte, ntp.PacketSizeBytes)
+		n, err := sendConn.Read(responseBytes)
 		require.Nil(t, err, "receiving response should not err")
+		err = response.UnmarshalBinary(responseBytes[:n])
+		require.Nil(t, err, "parsing response should not err")
 		require.Equal(t, sec, response.OrigTimeSec, "response Origin Time seconds should match our TX seconds")
 		require.Equal(t, frac, response.OrigTimeFrac, "response Origin Time fraction should match our TX fraction")
 	}
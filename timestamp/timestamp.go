@@ -97,13 +97,19 @@ func ReadPacketWithRXTimestamp(connFd int) ([]byte, unix.Sockaddr, time.Time, er
 // ReadPacketWithRXTimestampBuf writes byte packet into provide buffer buf, and returns number of bytes copied to the buffer, client ip and HW RX timestamp.
 // oob buffer can be reaused after ReadPacketWithRXTimestampBuf call.
 func ReadPacketWithRXTimestampBuf(connFd int, buf, oob []byte) (int, unix.Sockaddr, time.Time, error) {
+	bbuf, saddr, timestamp, _, err := ReadPacketWithRXTimestampSourceBuf(connFd, buf, oob)
+	return bbuf, saddr, timestamp, err
+}
+
+// ReadPacketWithRXTimestampSourceBuf is ReadPacketWithRXTimestampBuf which also reports if the RX timestamp came from hardware
+func ReadPacketWithRXTimestampSourceBuf(connFd int, buf, oob []byte) (int, unix.Sockaddr, time.Time, bool, error) {
 	bbuf, boob, _, saddr, err := unix.Recvmsg(connFd, buf, oob, 0)
 	if err != nil {
-		return 0, nil, time.Time{}, fmt.Errorf("failed to read timestamp: %v", err)
+		return 0, nil, time.Time{}, false, fmt.Errorf("failed to read timestamp: %w", err)
 	}
 
-	timestamp, err := socketControlMessageTimestamp(oob[:boob])
-	return bbuf, saddr, timestamp, err
+	timestamp, hw, err := socketControlMessageTimestampSource(oob[:boob])
+	return bbuf, saddr, timestamp, hw, err
 }
 
 // IPToSockaddr converts IP + port into a socket address
@@ -61,6 +61,13 @@ func socketControlMessageTimestamp(b []byte) (time.Time, error) {
 	return scmDataToTime(b[unix.CmsgSpace(0):])
 }
 
+// socketControlMessageTimestampSource is socketControlMessageTimestamp which also reports if the timestamp came from hardware.
+// Only software timestamps are supported here
+func socketControlMessageTimestampSource(b []byte) (time.Time, bool, error) {
+	ts, err := socketControlMessageTimestamp(b)
+	return ts, false, err
+}
+
 // EnableSWTimestampsRx enables SW RX timestamps on the socket
 func EnableSWTimestampsRx(connFd int) error {
 	// Allow reading of SW timestamps via socket
@@ -48,12 +48,18 @@ feature. Only one field is non-zero at any time. Most timestamps
 are passed in ts[0]. Hardware timestamps are passed in ts[2].
 */
 func scmDataToTime(data []byte) (ts time.Time, err error) {
+	ts, _, err = scmDataToTimeSource(data)
+	return ts, err
+}
+
+// scmDataToTimeSource is scmDataToTime which also reports if the timestamp came from hardware
+func scmDataToTimeSource(data []byte) (ts time.Time, hw bool, err error) {
 	// 2 x 64bit ints
 	size := 16
 	// first, try to use hardware timestamps
 	ts, err = byteToTime(data[size*2 : size*3])
 	if err != nil {
-		return ts, err
+		return ts, false, err
 	}
 	// if hw timestamps aren't present, use software timestamps
 	// we can't use ts.IsZero because for some crazy reason timestamp parsed using time.Unix()
@@ -61,14 +67,15 @@ func scmDataToTime(data []byte) (ts time.Time, err error) {
 	if ts.UnixNano() == 0 {
 		ts, err = byteToTime(data[0:size])
 		if err != nil {
-			return ts, err
+			return ts, false, err
 		}
 		if ts.UnixNano() == 0 {
-			return ts, fmt.Errorf("got zero timestamp")
+			return ts, false, fmt.Errorf("got zero timestamp")
 		}
+		return ts, false, nil
 	}
 
-	return ts, nil
+	return ts, true, nil
 }
 
 // byteToTime converts bytes into a timestamp
@@ -228,6 +235,12 @@ func ReadTXtimestamp(connFd int) (time.Time, int, error) {
 // https://github.com/golang/go/blob/2ebe77a2fda1ee9ff6fd9a3e08933ad1ebaea039/src/syscall/sockcmsg_unix.go#L40
 // which only parses the timestamp message type.
 func socketControlMessageTimestamp(b []byte) (time.Time, error) {
+	ts, _, err := socketControlMessageTimestampSource(b)
+	return ts, err
+}
+
+// socketControlMessageTimestampSource is socketControlMessageTimestamp which also reports if the timestamp came from hardware
+func socketControlMessageTimestampSource(b []byte) (time.Time, bool, error) {
 	mlen := 0
 	for i := 0; i < len(b); i += mlen {
 		h := (*unix.Cmsghdr)(unsafe.Pointer(&b[i]))
@@ -235,8 +248,8 @@ func socketControlMessageTimestamp(b []byte) (time.Time, error) {
 
 		// depending on the kernel version, when we ask for SO_TIMESTAMPING_NEW we still might get messages with type SO_TIMESTAMPING
 		if h.Level == unix.SOL_SOCKET && int(h.Type) == unix.SO_TIMESTAMPING_NEW || int(h.Type) == unix.SO_TIMESTAMPING {
-			return scmDataToTime(b[i+socketControlMessageHeaderOffset : i+mlen])
+			return scmDataToTimeSource(b[i+socketControlMessageHeaderOffset : i+mlen])
 		}
 	}
-	return time.Time{}, fmt.Errorf("failed to find timestamp in socket control message")
+	return time.Time{}, false, fmt.Errorf("failed to find timestamp in socket control message")
 }
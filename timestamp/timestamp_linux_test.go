@@ -104,12 +104,14 @@ func Test_scmDataToTime(t *testing.T) {
 		name    string
 		data    []byte
 		want    int64
+		wantHW  bool
 		wantErr bool
 	}{
 		{
 			name:    "hardware timestamp",
 			data:    hwData,
 			want:    1612028735717200436,
+			wantHW:  true,
 			wantErr: false,
 		},
 		{
@@ -134,6 +136,15 @@ func Test_scmDataToTime(t *testing.T) {
 				require.Nil(t, err)
 				require.Equal(t, tt.want, res.UnixNano())
 			}
+
+			res, hw, err := scmDataToTimeSource(tt.data)
+			if tt.wantErr {
+				require.Error(t, err)
+			} else {
+				require.Nil(t, err)
+				require.Equal(t, tt.want, res.UnixNano())
+				require.Equal(t, tt.wantHW, hw)
+			}
 		})
 	}
 }